@@ -4,16 +4,17 @@ build.go defines the 'build' subcommand and now outputs the SHA-256 hash.
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/004Ongoro/swiftstack/internal/builder"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
 )
 
+var buildSignKey string
+
 var buildCmd = &cobra.Command{
 	Use:   "build [source_dir] [output_file.tar.zst]",
 	Short: "Compress a directory and output its SHA-256 hash",
@@ -29,31 +30,33 @@ var buildCmd = &cobra.Command{
 		}
 
 		// Calculate hash for the manifest
-		hash, err := calculateHash(dest)
+		hash, err := utils.HashFile(dest)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error calculating hash: %v\n", err)
 			os.Exit(1)
 		}
 
 		fmt.Printf("\nSuccessfully created slice!\nLocation: %s\nSHA-256:  %s\n", dest, hash)
-		fmt.Println("\nhash to  registry.json to ensure security.")
-	},
-}
 
-func calculateHash(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+		// Optionally write a detached signature next to the slice
+		if buildSignKey != "" {
+			priv, err := trust.ReadPrivateKey(buildSignKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			sigPath, err := trust.SignSlice(priv, dest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Signature: %s (publish it next to the slice)\n", sigPath)
+		}
+		fmt.Println("\nAdd the hash to registry.json, or run 'swiftstack publish' to do it for you.")
+	},
 }
 
 func init() {
+	buildCmd.Flags().StringVarP(&buildSignKey, "sign-key", "k", "", "Private key (PEM) used to write a detached signature (<output>.sig)")
 	rootCmd.AddCommand(buildCmd)
 }
\ No newline at end of file
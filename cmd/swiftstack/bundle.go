@@ -0,0 +1,163 @@
+/*
+bundle.go defines the 'bundle' subcommands, which move a stack of slices to
+machines without network access in a single file.
+*/
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/engine"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/registry"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bundleStack   []string
+	bundleOutput  string
+	bundleSignKey string
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Carry stacks of slices to offline machines in a single file",
+}
+
+var bundleExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the manifest entries and archives of a stack to a bundle file",
+	Long: `Resolves each slice of --stack (an id or id@version) and everything it
+depends on, downloads what is not cached yet, and writes their manifest
+entries and archives to a single .ssb file. With --sign-key the bundle's
+manifest is signed, so machines that trust the key can check it on import.`,
+	Example: `  swiftstack bundle export --stack next-base,tailwind -o stack.ssb
+  swiftstack bundle export --stack next-base@14.0.0,next-auth -o stack.ssb --sign-key team.key`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(bundleStack) == 0 || bundleOutput == "" {
+			fmt.Println("Error: --stack and -o are required")
+			os.Exit(1)
+		}
+		var key ed25519.PrivateKey
+		if bundleSignKey != "" {
+			var err error
+			if key, err = trust.ReadPrivateKey(bundleSignKey); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		m, err := cache.LoadManifest()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		stack, err := registry.BundleStack(m, bundleStack)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		// The fetched slices stay locked against eviction until they are
+		// written into the bundle
+		var refs []string
+		var locks []*utils.FileLock
+		for _, list := range [][]models.SliceMetadata{stack.Bases, stack.Addons} {
+			for i := range list {
+				_, lock, err := engine.FetchSlice(&list[i], config.Get().Chunks)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				locks = append(locks, lock)
+				refs = append(refs, list[i].ID+"@"+list[i].Version)
+			}
+		}
+
+		// Write next to the destination and rename, so a failed export
+		// never leaves a truncated bundle behind
+		f, err := os.CreateTemp(filepath.Dir(bundleOutput), "."+filepath.Base(bundleOutput)+".*.tmp")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		err = registry.WriteBundle(f, stack, key)
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), bundleOutput)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		info, _ := os.Stat(bundleOutput)
+		fmt.Printf("✓ Wrote %s (%s): %s\n", bundleOutput, utils.FormatBytes(info.Size()), strings.Join(refs, ", "))
+		if key == nil {
+			fmt.Println("The bundle is unsigned; machines with trusted keys will refuse it. Sign it with --sign-key.")
+		}
+	},
+}
+
+var bundleImportCmd = &cobra.Command{
+	Use:   "import <file" + registry.BundleExt + ">",
+	Short: "Load a bundle into the cache and the local overlay manifest",
+	Long: `Verifies every archive of the bundle against its hash, stores them in the
+cache and adds the bundle's entries to the local overlay manifest, so
+'create' uses them without network access. Once any key is trusted (see
+'swiftstack keys'), the bundle must be signed by one of them.
+
+Overlay entries take priority over the registries: other versions of the
+imported slice ids are hidden until the entries are removed from the overlay.`,
+	Example: `  swiftstack bundle import stack.ssb`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		res, err := registry.ImportBundle(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if res.Signer != "" {
+			fmt.Printf("Signature verified (signed by '%s').\n", res.Signer)
+		}
+		for _, ref := range res.Added {
+			fmt.Printf("  + %s\n", ref)
+		}
+		for _, ref := range res.Updated {
+			fmt.Printf("  ~ %s (already listed, replaced)\n", ref)
+		}
+		overlay, _ := config.Get().LocalRegistryPath()
+		fmt.Printf("\n✓ Imported %d slices (%d new archives) into the cache and %s\n",
+			len(res.Added)+len(res.Updated), res.Stored, overlay)
+	},
+}
+
+func init() {
+	bundleExportCmd.Flags().StringSliceVar(&bundleStack, "stack", nil, "Comma-separated slices to bundle (e.g. next-base,tailwind@2.1.0); dependencies are added")
+	bundleExportCmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "Bundle file to write (e.g. stack"+registry.BundleExt+")")
+	bundleExportCmd.Flags().StringVar(&bundleSignKey, "sign-key", "", "Private key (PEM) used to sign the bundle's manifest")
+
+	bundleCmd.AddCommand(bundleExportCmd, bundleImportCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,287 @@
+/*
+cache.go defines the 'cache' subcommands for inspecting and trimming the
+local slice cache.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneMaxSize string
+	pruneMaxAge  string
+	pruneDryRun  bool
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect, verify and trim the local slice cache",
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached slices and git mirrors with their size and last use",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries := listCache()
+		if jsonOutput {
+			if entries == nil {
+				entries = []cache.Entry{}
+			}
+			printJSON(entries)
+			return
+		}
+		if len(entries) == 0 {
+			fmt.Println("The cache is empty.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tKIND\tSIZE\tLAST USED")
+		for i := len(entries) - 1; i >= 0; i-- { // most recently used first
+			e := entries[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Name, e.Kind, utils.FormatBytes(e.Size), formatAge(e.LastUsed))
+		}
+		w.Flush()
+	},
+}
+
+var cacheSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "Show the total size of the cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var total int64
+		entries := listCache()
+		for _, e := range entries {
+			total += e.Size
+		}
+		dir, _ := cache.GetCacheDir()
+		fmt.Printf("%s in %s (%s)\n", utils.FormatBytes(total), countEntries(len(entries)), dir)
+		if l := config.Get().CacheLimits; !l.Empty() {
+			fmt.Printf("Limits: max size %s, max age %s\n", orNone(l.MaxSize), orNone(l.MaxAge))
+		}
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Re-hash every cached slice and extracted tree",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m, err := cache.LoadManifest()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		results, err := cache.Verify(m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		var ok, corrupt, unknown int
+		for _, r := range results {
+			switch r.Status {
+			case cache.VerifyOK:
+				ok++
+			case cache.VerifyCorrupt:
+				corrupt++
+				fmt.Printf("✗ %s: %v\n", verifyName(r.Entry), r.Err)
+			default:
+				unknown++
+				if r.Err != nil {
+					fmt.Printf("? %s: %v\n", verifyName(r.Entry), r.Err)
+				} else {
+					fmt.Printf("? %s: not listed by any registry\n", verifyName(r.Entry))
+				}
+			}
+		}
+		fmt.Printf("\n%d ok, %d corrupt, %d unknown\n", ok, corrupt, unknown)
+		if corrupt > 0 {
+			fmt.Println("Remove corrupt entries with 'swiftstack cache clean <name>'; they are downloaded again on next use.")
+			os.Exit(1)
+		}
+	},
+}
+
+// verifyName tells a slice from its extracted tree, which share their refs.
+func verifyName(e cache.Entry) string {
+	if e.Kind == cache.EntryTree {
+		return e.Name + " (tree)"
+	}
+	return e.Name
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Evict least recently used entries to fit a size budget or maximum age",
+	Long: `Removes entries unused for longer than --max-age, then the least recently
+used ones until the cache is no larger than --max-size. A limit not given
+as a flag comes from cacheLimits in the configuration.`,
+	Example: `  swiftstack cache prune --max-size 2GB
+  swiftstack cache prune --max-age 30d --dry-run`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		limits := config.Get().CacheLimits
+		if cmd.Flags().Changed("max-size") {
+			limits.MaxSize = pruneMaxSize
+		}
+		if cmd.Flags().Changed("max-age") {
+			limits.MaxAge = pruneMaxAge
+		}
+		if limits.Empty() {
+			fmt.Println("Error: no limits given; pass --max-size and/or --max-age, or set cacheLimits in the config")
+			os.Exit(1)
+		}
+		size, err := config.ParseSize(limits.MaxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-size: %v\n", err)
+			os.Exit(1)
+		}
+		age, err := config.ParseAge(limits.MaxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-age: %v\n", err)
+			os.Exit(1)
+		}
+
+		evicted, err := cache.Prune(cache.PruneOptions{MaxSize: size, MaxAge: age, DryRun: pruneDryRun})
+		var freed int64
+		for _, e := range evicted {
+			freed += e.Size
+			fmt.Printf("  - %s (%s, last used %s)\n", e.Name, utils.FormatBytes(e.Size), formatAge(e.LastUsed))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		verb := "Removed"
+		if pruneDryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %s, %s.\n", verb, countEntries(len(evicted)), utils.FormatBytes(freed))
+	},
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean [name|id...]",
+	Short: "Remove cached slices and git mirrors (all of them without arguments)",
+	Long: `Removes the given entries, by id@version, slice id or git mirror name, or
+every cached slice and git mirror when none are given. Synced registry
+manifests are kept.`,
+	Example: `  swiftstack cache clean
+  swiftstack cache clean tailwind next-base@1.0.0`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var freed int64
+		removed, skipped := 0, 0
+		for _, e := range listCache() {
+			if len(args) > 0 && !matchesEntry(e, args) {
+				continue
+			}
+			err := cache.Remove(e)
+			if errors.Is(err, cache.ErrInUse) {
+				fmt.Printf("Skipped %s: in use by another swiftstack process\n", e.Name)
+				skipped++
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			freed += e.Size
+			removed++
+		}
+		if removed == 0 && skipped == 0 && len(args) > 0 {
+			fmt.Println("No matching cache entries.")
+			return
+		}
+		fmt.Printf("Removed %s, %s.\n", countEntries(removed), utils.FormatBytes(freed))
+	},
+}
+
+// matchesEntry reports whether any argument names the entry, one of its
+// refs (id@version) or the slice id of one of them.
+func matchesEntry(e cache.Entry, names []string) bool {
+	refs := e.Refs
+	if e.Kind == cache.EntryLegacy {
+		refs = []string{e.Name}
+	}
+	for _, n := range names {
+		if n == e.Name || n == e.Hash {
+			return true
+		}
+		for _, ref := range refs {
+			if id, _, _ := strings.Cut(ref, "@"); n == ref || n == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func listCache() []cache.Entry {
+	entries, err := cache.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return entries
+}
+
+// formatAge renders how long ago t was, coarsely ("3 days ago").
+func formatAge(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < 60*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	}
+	return t.Format("2006-01-02")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func countEntries(n int) string {
+	if n == 1 {
+		return "1 entry"
+	}
+	return fmt.Sprintf("%d entries", n)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func init() {
+	cachePruneCmd.Flags().StringVar(&pruneMaxSize, "max-size", "", "Size budget, e.g. 2GB or 500MiB")
+	cachePruneCmd.Flags().StringVar(&pruneMaxAge, "max-age", "", "Evict entries unused for longer than this, e.g. 30d, 2w or 72h")
+	cachePruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Show what would be removed without removing it")
+	cacheLsCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print machine-readable JSON")
+
+	cacheCmd.AddCommand(cacheLsCmd, cacheSizeCmd, cacheVerifyCmd, cachePruneCmd, cacheCleanCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -12,9 +12,13 @@ import (
 )
 
 var (
-	projectName string
-	baseAlias   string
-	addonsList  []string
+	projectName    string
+	baseAlias      string
+	addonsList     []string
+	outputPath     string
+	packageManager string
+	conflictPolicy string
+	materialize    string
 )
 
 var createCmd = &cobra.Command{
@@ -32,11 +36,15 @@ var createCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Empty values fall back to the configuration file / environment
 		options := engine.ProjectOptions{
-			Name:        projectName,
-			OutputPath:  ".",
-			BaseSlice:   baseAlias,
-			AddonSlices: addonsList,
+			Name:           projectName,
+			OutputPath:     outputPath,
+			BaseSlice:      baseAlias,
+			AddonSlices:    addonsList,
+			PackageManager: packageManager,
+			ConflictPolicy: conflictPolicy,
+			Materialize:    materialize,
 		}
 
 		fmt.Printf("🚀 Starting SwiftStack assembly for '%s'...\n", projectName)
@@ -52,8 +60,12 @@ var createCmd = &cobra.Command{
 
 func init() {
 	createCmd.Flags().StringVarP(&projectName, "name", "n", "", "Name of the project")
-	createCmd.Flags().StringVarP(&baseAlias, "base", "b", "", "Alias of the base slice (e.g., next-base)")
-	createCmd.Flags().StringSliceVarP(&addonsList, "addons", "a", []string{}, "Comma-separated aliases (e.g., tailwind,auth)")
+	createCmd.Flags().StringVarP(&baseAlias, "base", "b", "", "Alias of the base slice (e.g., next-base or next-base@1.2.0) or a git+ location")
+	createCmd.Flags().StringSliceVarP(&addonsList, "addons", "a", []string{}, "Comma-separated aliases (e.g., tailwind,auth@1.0.0)")
+	createCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Directory the project is created in (default from config, usually .)")
+	createCmd.Flags().StringVar(&packageManager, "package-manager", "", "Package manager for the lockfile update: npm, pnpm, yarn or bun")
+	createCmd.Flags().StringVar(&conflictPolicy, "conflict", "", "What to do when an addon overwrites a file: backup, overwrite, skip or fail")
+	createCmd.Flags().StringVar(&materialize, "materialize", "", "How slices are written: extract, or auto, reflink or copy from the extracted-tree cache")
 
 	rootCmd.AddCommand(createCmd)
 }
\ No newline at end of file
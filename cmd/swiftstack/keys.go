@@ -0,0 +1,182 @@
+/*
+keys.go defines the 'keys' subcommands for managing trusted signing keys.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keyOutput   string
+	keyTrust    bool
+	signKeyPath string
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the public keys trusted to sign registry manifests",
+}
+
+var keysAddCmd = &cobra.Command{
+	Use:     "add [name] [public_key|file.pem]",
+	Short:   "Trust a public key",
+	Example: "swiftstack keys add acme 3q2+7w...=",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, value := args[0], args[1]
+
+		// Accept a path to a PEM file as well as the inline key
+		if data, err := os.ReadFile(value); err == nil {
+			value = string(data)
+		}
+
+		pub, err := trust.ParsePublicKey(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		ring, err := trust.LoadKeyring()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ring.Add(name, pub); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ring.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		key, _ := ring.Find(name)
+		fmt.Printf("Trusted key '%s' (%s).\n", name, key.Fingerprint())
+		fmt.Println("Manifests must now be signed by a trusted key; run 'swiftstack sync' to re-verify.")
+	},
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List trusted public keys",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ring, err := trust.LoadKeyring()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(ring.Keys) == 0 {
+			fmt.Println("No trusted keys. Manifests are accepted without signatures.")
+			return
+		}
+		for _, k := range ring.Keys {
+			fmt.Printf("%-20s %s  %s\n", k.Name, k.Fingerprint(), k.PublicKey)
+		}
+	},
+}
+
+var keysRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Stop trusting a public key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ring, err := trust.LoadKeyring()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ring.Remove(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ring.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed key '%s'.\n", args[0])
+	},
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate [name]",
+	Short: "Create a new signing key pair",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		out := keyOutput
+		if out == "" {
+			out = name + ".key"
+		}
+
+		pub, priv, err := trust.GenerateKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := trust.WritePrivateKey(out, priv); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Private key: %s (keep this secret)\n", out)
+		fmt.Printf("Public key:  %s\n", trust.EncodePublicKey(pub))
+
+		if keyTrust {
+			ring, err := trust.LoadKeyring()
+			if err == nil {
+				err = ring.Add(name, pub)
+			}
+			if err == nil {
+				err = ring.Save()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Trusted key '%s'.\n", name)
+		}
+	},
+}
+
+var keysSignCmd = &cobra.Command{
+	Use:     "sign [file]",
+	Short:   "Write a detached signature (file.sig) for a manifest",
+	Example: "swiftstack keys sign registry.json --key acme.key",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if strings.TrimSpace(signKeyPath) == "" {
+			fmt.Println("Error: a private key is required (--key)")
+			os.Exit(1)
+		}
+
+		priv, err := trust.ReadPrivateKey(signKeyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sigPath, err := trust.SignFile(priv, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Signature written to %s\n", sigPath)
+		fmt.Println("Publish it next to the manifest so 'swiftstack sync' can verify it.")
+	},
+}
+
+func init() {
+	keysGenerateCmd.Flags().StringVarP(&keyOutput, "output", "o", "", "Private key file (default <name>.key)")
+	keysGenerateCmd.Flags().BoolVar(&keyTrust, "trust", false, "Also add the public key to the local keyring")
+	keysSignCmd.Flags().StringVarP(&signKeyPath, "key", "k", "", "Private key file (PEM)")
+
+	keysCmd.AddCommand(keysAddCmd, keysListCmd, keysRemoveCmd, keysGenerateCmd, keysSignCmd)
+	rootCmd.AddCommand(keysCmd)
+}
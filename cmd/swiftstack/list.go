@@ -0,0 +1,205 @@
+/*
+list.go defines the 'list', 'search' and 'info' commands for browsing the
+synced registries without opening the TUI.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listBases  bool
+	listAddons bool
+	jsonOutput bool
+)
+
+var listCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the slices available in the synced registries",
+	Example: "swiftstack list --addons",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		slices := loadCatalog()
+
+		var filtered []cache.SliceInfo
+		for _, s := range slices {
+			if listBases && s.Kind != "base" || listAddons && s.Kind != "addon" {
+				continue
+			}
+			filtered = append(filtered, s)
+		}
+		printSlices(filtered)
+	},
+}
+
+var searchCmd = &cobra.Command{
+	Use:     "search [term]",
+	Short:   "Fuzzy-search slices by id, title, description, category and tags",
+	Example: "swiftstack search tail",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		printSlices(cache.Search(loadCatalog(), args[0]))
+	},
+}
+
+var infoCmd = &cobra.Command{
+	Use:     "info [id]",
+	Short:   "Show every version of a slice, its hashes, sizes, cache state and dependencies",
+	Example: "swiftstack info tailwind",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var info *cache.SliceInfo
+		for _, s := range loadCatalog() {
+			if s.ID == args[0] {
+				info = &s
+				break
+			}
+		}
+		if info == nil {
+			fmt.Fprintf(os.Stderr, "Error: slice '%s' not found in registry. Try running 'swiftstack sync'\n", args[0])
+			os.Exit(1)
+		}
+		cache.MarkCached(info)
+
+		if jsonOutput {
+			printJSON(info)
+			return
+		}
+
+		fmt.Printf("%s (%s) - %s\n", info.ID, info.Kind, info.Title)
+		if info.Description != "" {
+			fmt.Printf("  %s\n", info.Description)
+		}
+		fmt.Printf("\nRegistry:      %s\n", info.Registry)
+		deps := "none"
+		if len(info.Dependencies) > 0 {
+			deps = strings.Join(info.Dependencies, ", ")
+		}
+		fmt.Printf("Dependencies:  %s\n", deps)
+		if info.Category != "" {
+			fmt.Printf("Category:      %s\n", info.Category)
+		}
+		if len(info.Tags) > 0 {
+			fmt.Printf("Tags:          %s\n", strings.Join(info.Tags, ", "))
+		}
+		if bases := info.Latest().CompatibleBases; len(bases) > 0 {
+			fmt.Printf("Works with:    %s\n", formatCompatible(bases))
+		}
+		fmt.Println("\nVersions:")
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, v := range info.Versions {
+			cached := ""
+			if v.Cached {
+				cached = "cached"
+			}
+			size := "-" // not listed in the manifest
+			if v.Size > 0 {
+				size = utils.FormatBytes(v.Size)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", v.Version, v.Hash, size, cached, lifecycleNote(v))
+		}
+		w.Flush()
+	},
+}
+
+// lifecycleNote describes a deprecated or yanked version for 'info'.
+func lifecycleNote(v cache.VersionInfo) string {
+	switch {
+	case v.Yanked != nil && v.Yanked.Reason != "":
+		return "yanked: " + v.Yanked.Reason
+	case v.Yanked != nil:
+		return "yanked"
+	case v.Deprecated == nil:
+		return ""
+	}
+	note := "deprecated"
+	if v.Deprecated.Message != "" {
+		note += ": " + v.Deprecated.Message
+	}
+	if v.Deprecated.Replacement != "" {
+		note += " (use " + v.Deprecated.Replacement + ")"
+	}
+	return note
+}
+
+// formatCompatible renders compatibleBases as "next-base ^14.0.0, vite-react".
+func formatCompatible(bases map[string]string) string {
+	ids := make([]string, 0, len(bases))
+	for id := range bases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for i, id := range ids {
+		if c := bases[id]; c != "" && c != "*" {
+			ids[i] += " " + c
+		}
+	}
+	return strings.Join(ids, ", ")
+}
+
+// loadCatalog loads the merged manifest of every registry, grouped by id.
+func loadCatalog() []cache.SliceInfo {
+	m, err := cache.LoadManifest()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return cache.Catalog(m)
+}
+
+// printSlices renders one line per slice, or the full records with --json.
+func printSlices(slices []cache.SliceInfo) {
+	if jsonOutput {
+		if slices == nil {
+			slices = []cache.SliceInfo{}
+		}
+		for i := range slices {
+			cache.MarkCached(&slices[i])
+		}
+		printJSON(slices)
+		return
+	}
+	if len(slices) == 0 {
+		fmt.Println("No slices found. Try running 'swiftstack sync'.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tLATEST\tTITLE")
+	for _, s := range slices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ID, s.Kind, s.Latest().Version, s.Title)
+	}
+	w.Flush()
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	listCmd.Flags().BoolVar(&listBases, "bases", false, "Only list base templates")
+	listCmd.Flags().BoolVar(&listAddons, "addons", false, "Only list addons")
+	listCmd.MarkFlagsMutuallyExclusive("bases", "addons")
+
+	for _, c := range []*cobra.Command{listCmd, searchCmd, infoCmd} {
+		c.Flags().BoolVar(&jsonOutput, "json", false, "Print machine-readable JSON")
+	}
+
+	rootCmd.AddCommand(listCmd, searchCmd, infoCmd)
+}
@@ -10,6 +10,11 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+
+	// Slice sources that register themselves with the downloader
+	_ "github.com/004Ongoro/swiftstack/internal/oci"
 )
 
 func main() {
@@ -27,8 +32,25 @@ var rootCmd = &cobra.Command{
 	Long: `A high-performance tool built in Go that assembles web projects 
 using pre-cached binary slices, eliminating the need for slow npm installs 
 on every new project setup.`,
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Load ~/.config/swiftstack/config.yaml, .swiftstack.yaml and SWIFTSTACK_* variables
+		if _, err := config.Load(); err != nil {
+			return err
+		}
+		// Downloads draw a progress bar on a terminal and log lines otherwise
+		utils.SetProgressHook(utils.ProgressWriter(os.Stderr, isTerminal(os.Stderr)))
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no arguments are provided, show help
 		cmd.Help()
 	},
-}
\ No newline at end of file
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a
+// pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,121 @@
+/*
+mirror.go defines the 'mirror' command, which copies the registries and
+their slices into a self-contained directory for air-gapped networks.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/registry"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mirrorTo      string
+	mirrorFilter  []string
+	mirrorUpdate  bool
+	mirrorSignKey string
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Copy the registries and their slices into a self-contained directory",
+	Long: `Syncs the configured registries, then downloads every listed slice (or
+those matching --filter, plus their dependencies) into a directory, verifies
+each hash and writes a registry.json with relative URLs. Copy the directory
+anywhere and point a registry at its registry.json.
+
+--update refreshes an existing mirror: only slices that changed upstream are
+downloaded and slices no longer listed are removed. The filter of the first
+run is reused unless --filter is given again.`,
+	Example: `  swiftstack mirror --to ./mirror --filter 'next-*,tailwind'
+  swiftstack mirror --to ./mirror --update --sign-key mirror.key`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if mirrorTo == "" {
+			fmt.Println("Error: a mirror directory is required (--to)")
+			os.Exit(1)
+		}
+
+		state, err := registry.ReadMirrorState(mirrorTo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		_, statErr := os.Stat(filepath.Join(mirrorTo, registry.MirrorStateFile))
+		switch exists := statErr == nil; {
+		case exists && !mirrorUpdate:
+			fmt.Printf("Error: %s is already a mirror; use --update to refresh it\n", mirrorTo)
+			os.Exit(1)
+		case !exists && mirrorUpdate:
+			fmt.Printf("Error: %s is not a mirror yet; run without --update first\n", mirrorTo)
+			os.Exit(1)
+		}
+		filter := mirrorFilter
+		if !cmd.Flags().Changed("filter") {
+			filter = state.Filter
+		}
+
+		if !syncRegistries() {
+			os.Exit(1)
+		}
+		// The local overlay holds private entries that must not leave this machine
+		m, err := cache.LoadSyncedManifest()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		before, _ := cache.ReadManifestFile(filepath.Join(mirrorTo, "registry.json"))
+
+		fmt.Printf("Mirroring into %s...\n", mirrorTo)
+		res, err := registry.Mirror(m, registry.MirrorOptions{
+			Dir:    mirrorTo,
+			Filter: filter,
+			Chunks: config.Get().Chunks,
+			Progress: func(ref string) {
+				fmt.Printf("  Downloading %s...\n", ref)
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nMirror failed: %v\n", err)
+			os.Exit(1)
+		}
+		if mirrorUpdate && before != nil {
+			if d := cache.DiffManifests(before, res.Manifest); !d.Empty() {
+				printManifestDiff(d)
+			}
+		}
+
+		if mirrorSignKey != "" {
+			priv, err := trust.ReadPrivateKey(mirrorSignKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if _, err := trust.SignFile(priv, filepath.Join(mirrorTo, "registry.json")); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("\n✓ Mirror ready: %d downloaded, %d unchanged, %d removed (%d bases, %d addons)\n",
+			len(res.Downloaded), len(res.Unchanged), len(res.Removed), len(res.Manifest.Bases), len(res.Manifest.Addons))
+		if mirrorSignKey == "" && !mirrorUpdate {
+			fmt.Println("The upstream manifest signatures do not cover the rewritten URLs; sign the mirror with --sign-key if consumers require signed manifests.")
+		}
+	},
+}
+
+func init() {
+	mirrorCmd.Flags().StringVar(&mirrorTo, "to", "", "Mirror directory (created if missing)")
+	mirrorCmd.Flags().StringSliceVar(&mirrorFilter, "filter", nil, "Only mirror slices whose ids match these patterns (e.g. 'next-*,tailwind'), plus their dependencies")
+	mirrorCmd.Flags().BoolVar(&mirrorUpdate, "update", false, "Refresh an existing mirror, downloading only what changed")
+	mirrorCmd.Flags().StringVar(&mirrorSignKey, "sign-key", "", "Private key (PEM) used to sign the mirror's registry.json")
+	rootCmd.AddCommand(mirrorCmd)
+}
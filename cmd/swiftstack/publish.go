@@ -0,0 +1,213 @@
+/*
+publish.go defines the 'publish' subcommand, which builds (if needed),
+uploads and registers a slice in one step.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/builder"
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/registry"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/blang/semver/v4"
+	"github.com/spf13/cobra"
+)
+
+var (
+	publishID          string
+	publishVersion     string
+	publishTitle       string
+	publishDescription string
+	publishKind        string
+	publishDeps        []string
+	publishCategory    string
+	publishTags        []string
+	publishCompatible  []string
+	publishTo          string
+	publishSignKey     string
+	publishManifestKey string
+	publishBackend     string
+)
+
+var publishCmd = &cobra.Command{
+	Use:   "publish [dir|slice.tar.zst]",
+	Short: "Build, upload and register a slice in the configured registry",
+	Example: `  swiftstack publish ./tailwind --id tailwind --version 1.2.0
+  swiftstack publish next-base.tar.zst --id next-base --version 2.0.0 --kind base --to ./registry
+  swiftstack publish ./auth --id auth --version 0.1.0 --to s3://slices/prod
+  swiftstack publish ./next-auth --id next-auth --version 1.0.0 --category auth --compatible 'next-base=^14.0.0'
+  swiftstack publish ./auth --id auth --version 0.1.0 --to oci://registry.local/slices
+  swiftstack publish ./auth --id acme-auth --version 0.1.0 --to https://slices.acme.dev --backend server`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if publishID == "" || publishVersion == "" {
+			fmt.Println("Error: --id and --version are required")
+			os.Exit(1)
+		}
+		if _, err := semver.Parse(publishVersion); err != nil {
+			fmt.Printf("Error: --version '%s' is not valid semver (e.g. 1.2.0)\n", publishVersion)
+			os.Exit(1)
+		}
+		if publishKind != "base" && publishKind != "addon" {
+			fmt.Println("Error: --kind must be 'base' or 'addon'")
+			os.Exit(1)
+		}
+
+		compatible, err := parseCompatible(publishCompatible)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		pcfg := config.Get().Publish
+		if publishTo != "" {
+			pcfg = publishTarget(publishTo, pcfg)
+		}
+		if publishBackend != "" {
+			pcfg.Backend = publishBackend
+		}
+		target, err := registry.NewTarget(pcfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 1. Build the slice if we were given a directory
+		slicePath := args[0]
+		info, err := os.Stat(slicePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		// os.Exit skips deferred calls, so failures clean up through fail
+		var tmpDir string
+		fail := func(prefix string, err error) {
+			if tmpDir != "" {
+				os.RemoveAll(tmpDir)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+			os.Exit(1)
+		}
+		if info.IsDir() {
+			tmpDir, err = os.MkdirTemp("", "swiftstack-publish-*")
+			if err != nil {
+				fail("Error", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			built := filepath.Join(tmpDir, fmt.Sprintf("%s@%s.tar.zst", publishID, publishVersion))
+			fmt.Printf("Building slice from %s...\n", slicePath)
+			if err := builder.CreateSlice(slicePath, built); err != nil {
+				fail("Error", err)
+			}
+			slicePath = built
+		}
+
+		// 2. Optional signatures
+		req := registry.PublishRequest{
+			SlicePath: slicePath,
+			Kind:      publishKind,
+			Slice: models.SliceMetadata{
+				ID:           publishID,
+				Version:      publishVersion,
+				Title:        publishTitle,
+				Description:  publishDescription,
+				Dependencies: publishDeps,
+
+				Category:        publishCategory,
+				Tags:            publishTags,
+				CompatibleBases: compatible,
+			},
+		}
+		if publishSignKey != "" {
+			priv, err := trust.ReadPrivateKey(publishSignKey)
+			if err != nil {
+				fail("Error", err)
+			}
+			if req.SignaturePath, err = trust.SignSlice(priv, slicePath); err != nil {
+				fail("Error", err)
+			}
+		} else if _, err := os.Stat(slicePath + trust.SignatureExt); err == nil {
+			// Reuse a signature written by 'swiftstack build --sign-key'
+			req.SignaturePath = slicePath + trust.SignatureExt
+		}
+		if publishManifestKey != "" {
+			if req.ManifestKey, err = trust.ReadPrivateKey(publishManifestKey); err != nil {
+				fail("Error", err)
+			}
+		}
+
+		// 3. Upload and update the manifest
+		fmt.Printf("Publishing %s@%s...\n", publishID, publishVersion)
+		entry, err := target.Publish(context.Background(), req)
+		if err != nil {
+			fail("Publish failed", err)
+		}
+
+		fmt.Printf("\nPublished %s@%s\nURL:      %s\nSHA-256:  %s\nSize:     %d bytes\n",
+			entry.ID, entry.Version, entry.URL, entry.Hash, entry.Size)
+	},
+}
+
+// parseCompatible turns --compatible values ("next-base" or
+// "next-base=^14.0.0") into compatibleBases.
+func parseCompatible(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string)
+	for _, v := range values {
+		id, constraint, _ := strings.Cut(v, "=")
+		if id = strings.TrimSpace(id); id == "" {
+			return nil, fmt.Errorf("--compatible %q has no base id", v)
+		}
+		if _, err := cache.ParseConstraint(constraint); err != nil {
+			return nil, fmt.Errorf("--compatible %s: %w", id, err)
+		}
+		out[id] = strings.TrimSpace(constraint)
+	}
+	return out, nil
+}
+
+// publishTarget turns --to into a backend configuration:
+// s3://bucket/prefix, oci://host/prefix, http(s)://base-url or a local directory.
+func publishTarget(to string, base config.PublishConfig) config.PublishConfig {
+	switch {
+	case strings.HasPrefix(to, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(to, "s3://"), "/")
+		base.Backend, base.Bucket, base.Prefix = "s3", bucket, prefix
+	case strings.HasPrefix(to, "oci://"):
+		base.Backend, base.URL = "oci", to
+	case strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://"):
+		base.Backend, base.URL = "http", to
+	default:
+		base.Backend, base.Path = "local", to
+	}
+	return base
+}
+
+func init() {
+	publishCmd.Flags().StringVar(&publishID, "id", "", "Slice id (e.g. tailwind)")
+	publishCmd.Flags().StringVar(&publishVersion, "version", "", "Slice version (semver)")
+	publishCmd.Flags().StringVar(&publishTitle, "title", "", "Display title (defaults to the previous version's)")
+	publishCmd.Flags().StringVar(&publishDescription, "description", "", "Description (defaults to the previous version's)")
+	publishCmd.Flags().StringVar(&publishKind, "kind", "addon", "Whether the slice is a 'base' or an 'addon'")
+	publishCmd.Flags().StringSliceVar(&publishDeps, "deps", nil, "Comma-separated ids this slice depends on")
+	publishCmd.Flags().StringVar(&publishCategory, "category", "", "Category the wizard groups the addon under (defaults to the previous version's)")
+	publishCmd.Flags().StringSliceVar(&publishTags, "tags", nil, "Comma-separated search tags (default: the previous version's)")
+	publishCmd.Flags().StringSliceVar(&publishCompatible, "compatible", nil, "Bases the addon works with, as id or id=constraint (e.g. 'next-base=^14.0.0')")
+	publishCmd.Flags().StringVar(&publishTo, "to", "", "Override the configured backend: a directory, http(s):// URL, s3://bucket/prefix or oci://host/prefix")
+	publishCmd.Flags().StringVar(&publishSignKey, "sign-key", "", "Private key (PEM) used to sign the slice")
+	publishCmd.Flags().StringVar(&publishManifestKey, "manifest-key", "", "Private key (PEM) used to re-sign the updated manifest")
+	publishCmd.Flags().StringVar(&publishBackend, "backend", "", "Override the backend type (local, http, s3, oci or server)")
+
+	rootCmd.AddCommand(publishCmd)
+}
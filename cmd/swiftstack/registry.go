@@ -0,0 +1,120 @@
+/*
+registry.go defines the 'registry' subcommands used by registry maintainers.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/registry"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/spf13/cobra"
+)
+
+var (
+	validateDownload bool
+	serveAddr        string
+	servePublicURL   string
+	serveSignKey     string
+	serveTokens      string
+	serveMaxUpload   int64
+)
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Tools for maintaining a slice registry",
+}
+
+var registryValidateCmd = &cobra.Command{
+	Use:     "validate [registry.json]",
+	Short:   "Check a manifest for duplicate ids, bad hashes, versions, URLs and dependencies",
+	Example: "swiftstack registry validate registry.json --download",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m, err := registry.LoadFile(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		issues := registry.Validate(m)
+		if validateDownload {
+			issues = append(issues, registry.VerifyDownloads(m, args[0], config.Get().Chunks, func(ref string) {
+				fmt.Printf("Checking %s...\n", ref)
+			})...)
+		}
+
+		if len(issues) > 0 {
+			fmt.Printf("\n%s has %d problem(s):\n", args[0], len(issues))
+			for _, issue := range issues {
+				fmt.Printf("  ✗ %s\n", issue)
+			}
+			os.Exit(1)
+		}
+
+		fmt.Printf("✓ %s is valid (%d bases, %d addons)\n", args[0], len(m.Bases), len(m.Addons))
+	},
+}
+
+var registryServeCmd = &cobra.Command{
+	Use:   "serve [dir]",
+	Short: "Serve a directory of .tar.zst slices with a generated manifest",
+	Long: `Serves every id@version.tar.zst file under dir (files in dir/bases/ are
+listed as bases) with HEAD, Range and ETag support, and a manifest generated
+from them at /registry.json. Titles, descriptions and kinds are taken from a
+registry.json in dir or from optional id@version.json files next to each slice.
+
+With --tokens, the server also accepts uploads at PUT /api/v1/slices/{id}/{version}
+('swiftstack publish --to http://host --backend server'). Each token may only
+publish ids matching its namespaces:
+
+  tokens:
+    - name: ci
+      sha256: <hex sha256 of the token>
+      namespaces: ["acme-*"]`,
+	Example: `  swiftstack registry serve ./slices --addr :8080
+  swiftstack registry serve ./slices --tokens tokens.yaml --sign-key registry.key`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := registry.ServerOptions{Dir: args[0], PublicURL: servePublicURL}
+		if serveSignKey != "" {
+			key, err := trust.ReadPrivateKey(serveSignKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.SignKey = key
+		}
+		if serveTokens != "" {
+			tokens, err := registry.LoadTokens(serveTokens)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Tokens = tokens
+			opts.MaxUploadSize = serveMaxUpload
+			fmt.Printf("Publishing enabled for %d token(s) at %s\n", len(tokens), registry.APIPrefix)
+		}
+
+		fmt.Printf("Serving %s on %s (manifest at %s)\n", args[0], serveAddr, registry.ManifestPath)
+		if err := registry.ListenAndServe(serveAddr, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	registryServeCmd.Flags().StringVar(&serveAddr, "addr", ":8080", "Address to listen on")
+	registryServeCmd.Flags().StringVar(&servePublicURL, "public-url", "", "Base URL written into the manifest (default: derived from each request's Host)")
+	registryServeCmd.Flags().StringVar(&serveSignKey, "sign-key", "", "Private key (PEM) used to sign the generated manifest")
+	registryServeCmd.Flags().StringVar(&serveTokens, "tokens", "", "YAML file of publish tokens; enables the publish API")
+	registryServeCmd.Flags().Int64Var(&serveMaxUpload, "max-upload-size", registry.DefaultMaxUploadSize, "Largest slice accepted by the publish API, in bytes")
+
+	registryValidateCmd.Flags().BoolVar(&validateDownload, "download", false, "Also download every slice and confirm its SHA-256")
+
+	registryCmd.AddCommand(registryValidateCmd, registryServeCmd)
+	rootCmd.AddCommand(registryCmd)
+}
@@ -1,41 +1,125 @@
 /*
-sync.go defines the command to update the local registry manifest.
+sync.go defines the command to update the local registry manifests.
 */
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
 	"github.com/004Ongoro/swiftstack/internal/utils"
 )
 
-// For now, this points to your personal repo or a placeholder
-const ManifestURL = "https://raw.githubusercontent.com/004Ongoro/swiftstack/main/registry.json"
+var syncRollback bool
 
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Update the local slice registry",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Syncing with remote registry...")
+		if syncRollback {
+			rollbackManifests()
+			return
+		}
+
+		if !syncRegistries() {
+			os.Exit(1)
+		}
+		fmt.Println("Registry updated successfully!")
+	},
+}
+
+// syncRegistries fetches every configured registry's manifest and prints
+// what changed. It reports whether all of them synced.
+func syncRegistries() bool {
+	failed := false
 
-		dest, err := cache.GetManifestPath()
+	for _, reg := range config.Get().Registries {
+		fmt.Printf("Syncing with registry '%s' (%s)...\n", reg.Name, utils.RedactURL(reg.URL))
+
+		dest, err := cache.GetManifestPath(reg.Name)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
-		if err := utils.FetchRemoteManifest(ManifestURL, dest); err != nil {
-			fmt.Fprintf(os.Stderr, "Sync failed: %v\n", err)
+		before, err := cache.ReadManifestFile(dest)
+		if err != nil {
+			before = &models.RemoteManifest{}
+		}
+
+		changed, err := utils.FetchRemoteManifest(reg.URL, dest, trust.VerifyManifest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sync of '%s' failed: %v\n", reg.Name, err)
+			failed = true
+			continue
+		}
+		if !changed {
+			fmt.Println("  Already up to date.")
+			continue
+		}
+
+		after, err := cache.ReadManifestFile(dest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sync of '%s' failed: %v\n", reg.Name, err)
+			failed = true
+			continue
+		}
+		printManifestDiff(cache.DiffManifests(before, after))
+	}
+	return !failed
+}
+
+// printManifestDiff summarizes the slices a sync added, removed or bumped.
+func printManifestDiff(d cache.ManifestDiff) {
+	if d.Empty() {
+		fmt.Println("  Manifest updated; no slices were added, removed or bumped.")
+		return
+	}
+	for _, s := range d.Added {
+		fmt.Printf("  + %s@%s (new %s)\n", s.ID, s.Latest().Version, s.Kind)
+	}
+	for _, c := range d.Updated {
+		fmt.Printf("  ↑ %s %s → %s\n", c.ID, c.From, c.To)
+	}
+	for _, s := range d.Removed {
+		fmt.Printf("  - %s (removed)\n", s.ID)
+	}
+}
+
+// rollbackManifests restores the manifest each registry had before its last sync.
+func rollbackManifests() {
+	restored := 0
+	for _, reg := range config.Get().Registries {
+		dest, err := cache.GetManifestPath(reg.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
-		fmt.Println("Registry updated successfully!")
-	},
+		err = utils.RestorePreviousManifest(dest)
+		if errors.Is(err, utils.ErrNoPreviousManifest) {
+			fmt.Printf("No previous manifest kept for '%s'.\n", reg.Name)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback of '%s' failed: %v\n", reg.Name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Restored the previous manifest of '%s'.\n", reg.Name)
+		restored++
+	}
+	if restored == 0 {
+		os.Exit(1)
+	}
 }
 
 func init() {
+	syncCmd.Flags().BoolVar(&syncRollback, "rollback", false, "Restore the manifests replaced by the last sync")
 	rootCmd.AddCommand(syncCmd)
 }
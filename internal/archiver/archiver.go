@@ -6,8 +6,12 @@ package archiver
 
 import (
 	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -66,4 +70,70 @@ func Extract(src io.Reader, dest string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Compare checks that dir holds exactly what Extract would write from the
+// .tar.zst archive src: every regular file with the same content, and no
+// file the archive does not contain.
+func Compare(src io.Reader, dir string) error {
+	zr, err := zstd.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	want := make(map[string]bool)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar header: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Clean(header.Name)
+		want[name] = true
+		if err := sameContent(tr, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("%s: %w", filepath.ToSlash(name), err)
+		}
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !want[rel] {
+			return fmt.Errorf("%s: not in the archive", filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
+
+// sameContent reports an error unless the file at path holds exactly what r
+// yields.
+func sameContent(r io.Reader, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a, b := sha256.New(), sha256.New()
+	if _, err := io.Copy(a, r); err != nil {
+		return err
+	}
+	if _, err := io.Copy(b, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(a.Sum(nil), b.Sum(nil)) {
+		return errors.New("content differs from the archive")
+	}
+	return nil
+}
@@ -74,3 +74,69 @@ func CreateSlice(srcDir, targetFile string) error {
 		return err
 	})
 }
+
+// CreateSliceFromTar compresses an uncompressed tar stream (such as the
+// output of 'git archive') into a target .tar.zst file. Entries keep the
+// names, modes and timestamps of the stream, so the same input always yields
+// the same slice.
+func CreateSliceFromTar(src io.Reader, targetFile string) error {
+	f, err := os.Create(targetFile)
+	if err != nil {
+		return fmt.Errorf("builder: failed to create output file: %w", err)
+	}
+	defer f.Close()
+
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		return fmt.Errorf("builder: failed to create zstd writer: %w", err)
+	}
+
+	tr := tar.NewReader(src)
+	tw := tar.NewWriter(zw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("builder: failed to read tar stream: %w", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			// Global headers (git archive stores the commit id there) carry no file
+			continue
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			// Extraction only writes files and directories; anything else
+			// would silently go missing from projects
+			return fmt.Errorf("builder: %s is %s, which slices cannot hold (only regular files and directories)",
+				header.Name, entryKind(header.Typeflag))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("builder: %w", err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("builder: %w", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+	return f.Close()
+}
+
+// entryKind describes a tar entry type for error messages.
+func entryKind(t byte) string {
+	switch t {
+	case tar.TypeSymlink:
+		return "a symbolic link"
+	case tar.TypeLink:
+		return "a hard link"
+	default:
+		return fmt.Sprintf("an entry of type %q", t)
+	}
+}
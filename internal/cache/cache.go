@@ -5,19 +5,27 @@ It ensures that slices are stored in the standard OS cache directories.
 package cache
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/utils"
 )
 
-// GetCacheDir returns the OS-standard path for SwiftStack data.
+// GetCacheDir returns the configured cache directory, falling back to the
+// OS-standard path for SwiftStack data.
 func GetCacheDir() (string, error) {
-	parent, err := os.UserCacheDir()
-	if err != nil {
-		return "", fmt.Errorf("cache: could not determine user cache dir: %w", err)
+	path := config.Get().CacheDir
+	if path == "" {
+		parent, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("cache: could not determine user cache dir: %w", err)
+		}
+		path = filepath.Join(parent, "swiftstack")
 	}
-
-	path := filepath.Join(parent, "swiftstack")
 	
 	// Ensure the directory exists
 	if err := os.MkdirAll(path, 0755); err != nil {
@@ -27,14 +35,222 @@ func GetCacheDir() (string, error) {
 	return path, nil
 }
 
-// GetSlicePath returns the full local path for a specific slice version.
-func GetSlicePath(sliceID, version string) (string, error) {
+// Slice archives are stored once per content, as blobs/sha256/<hash>, and
+// refs/<id>@<version>.json points at the blob of each version. A blob is
+// only moved into place after its hash was verified, so its path vouches for
+// its content and it is not re-hashed on every use. With a materialize mode
+// other than extract, trees/<hash> also holds the blob extracted.
+const (
+	blobDir = "blobs/sha256"
+	refDir  = "refs"
+	treeDir = "trees"
+)
+
+// Ref is the reference from an id@version to the blob holding its archive.
+// For slices packed from git, it also records what they were packed from.
+type Ref struct {
+	Hash   string `json:"hash"`
+	Source string `json:"source,omitempty"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// Lock takes the inter-process lock guarding one cache entry, such as
+// "sha256-<hash>" while a blob is downloaded, and blocks until no other
+// process holds it. waiting is called first if one does.
+func Lock(key string, waiting func()) (*utils.FileLock, error) {
+	path, err := lockPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Lock(path, waiting)
+}
+
+// RLock takes the shared lock of a cache entry, held while a project reads
+// it so that Remove leaves it alone. It waits while the entry is being
+// written under Lock.
+func RLock(key string, waiting func()) (*utils.FileLock, error) {
+	path, err := lockPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return utils.RLock(path, waiting)
+}
+
+func lockPath(key string) (string, error) {
 	dir, err := GetCacheDir()
 	if err != nil {
 		return "", err
 	}
-	
-	// Slices are stored as: id@version.tar.zst
-	filename := fmt.Sprintf("%s@%s.tar.zst", sliceID, version)
-	return filepath.Join(dir, filename), nil
-}
\ No newline at end of file
+	name := strings.NewReplacer("/", "_", `\`, "_", ":", "_").Replace(key)
+	return filepath.Join(dir, "locks", name+".lock"), nil
+}
+
+// BlobPath returns where the slice archive with the given SHA-256 is stored.
+// The file does not have to exist.
+func BlobPath(hash string) (string, error) {
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("cache: %q is not a SHA-256 hash", hash)
+	}
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(blobDir), hash), nil
+}
+
+// HasBlob reports whether the blob with the given hash is stored.
+func HasBlob(hash string) bool {
+	path, err := BlobPath(hash)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// TempPath returns a new path for a download inside the cache, on the same
+// file system as the blobs so that StoreBlob can rename it into place.
+func TempPath() (string, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	tmpDir := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	f, err := os.CreateTemp(tmpDir, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// TreePath returns where the extracted tree of the blob with the given hash
+// is kept. The directory does not have to exist.
+func TreePath(hash string) (string, error) {
+	if _, err := BlobPath(hash); err != nil {
+		return "", err
+	}
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, treeDir, hash), nil
+}
+
+// TempDir returns a new directory inside the cache to extract a tree into
+// before StoreTree renames it into place.
+func TempDir() (string, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	tmpDir := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	path, err := os.MkdirTemp(tmpDir, "tree-*")
+	if err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	return path, nil
+}
+
+// PartialPath returns where the blob with the given hash is downloaded to.
+// Unlike TempPath it is the same for every attempt, so a download that was
+// interrupted is resumed rather than started over. Callers hold the blob's
+// lock while using it.
+func PartialPath(hash string) (string, error) {
+	if _, err := BlobPath(hash); err != nil {
+		return "", err
+	}
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	tmpDir := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	return filepath.Join(tmpDir, "sha256-"+hash), nil
+}
+
+// StoreBlob moves the file at tmp, whose SHA-256 the caller has verified to
+// be hash, into the blob store and returns the blob path.
+func StoreBlob(tmp, hash string) (string, error) {
+	path, err := BlobPath(hash)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("cache: failed to store blob: %w", err)
+	}
+	return path, nil
+}
+
+// StoreTree moves the directory tmp, extracted from the blob with the given
+// hash, into the tree cache and returns its path.
+func StoreTree(tmp, hash string) (string, error) {
+	path, err := TreePath(hash)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("cache: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("cache: failed to store extracted tree: %w", err)
+	}
+	return path, nil
+}
+
+// ReadRef returns the reference of id@version.
+func ReadRef(id, version string) (Ref, error) {
+	var ref Ref
+	path, err := refPath(id, version)
+	if err != nil {
+		return ref, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ref, err
+	}
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return ref, fmt.Errorf("cache: invalid reference %s: %w", path, err)
+	}
+	return ref, nil
+}
+
+// WriteRef points id@version at a blob.
+func WriteRef(id, version string, ref Ref) error {
+	path, err := refPath(id, version)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	data, err := json.MarshalIndent(ref, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0644)
+}
+
+func refPath(id, version string) (string, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, refDir, refName(id, version)+".json"), nil
+}
+
+// refName is the file name of a reference, without path separators.
+func refName(id, version string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(id + "@" + version)
+}
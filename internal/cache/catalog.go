@@ -0,0 +1,250 @@
+/*
+Package cache handles local storage and remote resolution of the project manifest.
+catalog.go groups the merged manifest by slice id for the list, search and
+info commands, and diffs manifests for the sync summary.
+*/
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/blang/semver/v4"
+	"github.com/sahilm/fuzzy"
+)
+
+// SliceInfo is every version of a slice id listed by a registry.
+type SliceInfo struct {
+	ID           string        `json:"id"`
+	Kind         string        `json:"kind"` // "base" or "addon"
+	Title        string        `json:"title"`
+	Description  string        `json:"description"`
+	Category     string        `json:"category,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	Registry     string        `json:"registry"`
+	Dependencies []string      `json:"dependencies,omitempty"`
+	Versions     []VersionInfo `json:"versions"` // newest first
+}
+
+// VersionInfo describes a single published version.
+type VersionInfo struct {
+	Version string `json:"version"`
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size,omitempty"`
+	URL     string `json:"url"`
+	Cached  bool   `json:"cached"`
+
+	CompatibleBases map[string]string   `json:"compatibleBases,omitempty"`
+	Deprecated      *models.Deprecation `json:"deprecated,omitempty"`
+	Yanked          *models.Yank        `json:"yanked,omitempty"`
+}
+
+// Latest returns the newest version of the slice that has not been yanked,
+// or the newest version when all of them were.
+func (s SliceInfo) Latest() VersionInfo {
+	for _, v := range s.Versions {
+		if v.Yanked == nil {
+			return v
+		}
+	}
+	if len(s.Versions) == 0 {
+		return VersionInfo{}
+	}
+	return s.Versions[0]
+}
+
+// Catalog groups the manifest entries by id, bases first, each sorted by id.
+// Descriptive fields come from the newest version.
+func Catalog(m *models.RemoteManifest) []SliceInfo {
+	var out []SliceInfo
+	for _, group := range []struct {
+		kind string
+		list []models.SliceMetadata
+	}{{"base", m.Bases}, {"addon", m.Addons}} {
+		byID := make(map[string][]models.SliceMetadata)
+		var ids []string
+		for _, s := range group.list {
+			if _, ok := byID[s.ID]; !ok {
+				ids = append(ids, s.ID)
+			}
+			byID[s.ID] = append(byID[s.ID], s)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			out = append(out, sliceInfo(group.kind, byID[id]))
+		}
+	}
+	return out
+}
+
+func sliceInfo(kind string, entries []models.SliceMetadata) SliceInfo {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareVersions(entries[i].Version, entries[j].Version) > 0
+	})
+
+	newest := entries[0]
+	info := SliceInfo{
+		ID:           newest.ID,
+		Kind:         kind,
+		Title:        newest.Title,
+		Description:  newest.Description,
+		Category:     newest.Category,
+		Tags:         newest.Tags,
+		Registry:     newest.Registry,
+		Dependencies: newest.Dependencies,
+	}
+	for _, e := range entries {
+		info.Versions = append(info.Versions, VersionInfo{
+			Version: e.Version,
+			Hash:    e.Hash,
+			Size:    e.Size,
+			URL:     e.URL,
+
+			CompatibleBases: e.CompatibleBases,
+			Deprecated:      e.Deprecated,
+			Yanked:          e.Yanked,
+		})
+	}
+	return info
+}
+
+// compareVersions orders semver versions, falling back to string order for
+// versions that don't parse.
+func compareVersions(a, b string) int {
+	va, errA := semver.Parse(a)
+	vb, errB := semver.Parse(b)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Search fuzzy-matches term against the id, title, description, category
+// and tags of each slice and returns the matches, best first.
+func Search(slices []SliceInfo, term string) []SliceInfo {
+	best := make(map[int]int) // index into slices -> best score
+	fields := []func(SliceInfo) string{
+		func(s SliceInfo) string { return s.ID },
+		func(s SliceInfo) string { return s.Title },
+		func(s SliceInfo) string { return s.Description },
+		func(s SliceInfo) string { return s.Category },
+		func(s SliceInfo) string { return strings.Join(s.Tags, " ") },
+	}
+	for _, field := range fields {
+		for _, match := range fuzzy.FindFrom(term, searchSource{slices, field}) {
+			if score, ok := best[match.Index]; !ok || match.Score > score {
+				best[match.Index] = match.Score
+			}
+		}
+	}
+
+	indexes := make([]int, 0, len(best))
+	for i := range best {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		if best[indexes[a]] != best[indexes[b]] {
+			return best[indexes[a]] > best[indexes[b]]
+		}
+		return slices[indexes[a]].ID < slices[indexes[b]].ID
+	})
+
+	out := make([]SliceInfo, 0, len(indexes))
+	for _, i := range indexes {
+		out = append(out, slices[i])
+	}
+	return out
+}
+
+// searchSource adapts one field of the catalog to fuzzy.Source.
+type searchSource struct {
+	slices []SliceInfo
+	field  func(SliceInfo) string
+}
+
+func (s searchSource) String(i int) string { return s.field(s.slices[i]) }
+func (s searchSource) Len() int            { return len(s.slices) }
+
+// MarkCached sets Cached on every version whose archive is in the blob store.
+func MarkCached(info *SliceInfo) {
+	for i := range info.Versions {
+		info.Versions[i].Cached = HasBlob(info.Versions[i].Hash)
+	}
+}
+
+// ManifestDiff summarizes what a sync changed in a registry.
+type ManifestDiff struct {
+	Added   []SliceInfo     // ids that were not listed before
+	Removed []SliceInfo     // ids that are no longer listed
+	Updated []VersionChange // ids whose latest version changed
+}
+
+// VersionChange is a change of the latest version of a slice.
+type VersionChange struct {
+	ID   string
+	From string
+	To   string
+}
+
+// Empty reports whether the diff has nothing to show.
+func (d ManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Updated) == 0
+}
+
+// DiffManifests compares two manifests by slice id and latest version.
+func DiffManifests(before, after *models.RemoteManifest) ManifestDiff {
+	old := make(map[string]SliceInfo)
+	for _, s := range Catalog(before) {
+		old[s.ID] = s
+	}
+
+	var d ManifestDiff
+	for _, s := range Catalog(after) {
+		prev, ok := old[s.ID]
+		delete(old, s.ID)
+		switch {
+		case !ok:
+			d.Added = append(d.Added, s)
+		case prev.Latest().Version != s.Latest().Version:
+			d.Updated = append(d.Updated, VersionChange{s.ID, prev.Latest().Version, s.Latest().Version})
+		}
+	}
+	for _, s := range Catalog(before) {
+		if _, ok := old[s.ID]; ok {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	return d
+}
+
+// ReadManifestFile parses a manifest file without verifying it, returning an
+// empty manifest when the file does not exist.
+func ReadManifestFile(path string) (*models.RemoteManifest, error) {
+	m := &models.RemoteManifest{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("cache: failed to parse %s: %w", path, err)
+	}
+	return m, nil
+}
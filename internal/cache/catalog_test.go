@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+)
+
+func TestCatalogAndSearch(t *testing.T) {
+	m := &models.RemoteManifest{
+		Bases: []models.SliceMetadata{
+			{ID: "next-base", Title: "Next.js", Version: "1.0.0"},
+		},
+		Addons: []models.SliceMetadata{
+			{ID: "tailwind", Title: "Tailwind", Version: "1.9.0"},
+			{ID: "tailwind", Title: "Tailwind CSS", Version: "1.10.0", Description: "Utility-first styling"},
+			{ID: "auth", Title: "Auth", Description: "Sessions and login"},
+		},
+	}
+
+	slices := Catalog(m)
+	if len(slices) != 3 || slices[0].ID != "next-base" || slices[1].ID != "auth" {
+		t.Fatalf("unexpected catalog order: %+v", slices)
+	}
+	tw := slices[2]
+	if tw.Latest().Version != "1.10.0" || tw.Title != "Tailwind CSS" || len(tw.Versions) != 2 {
+		t.Errorf("tailwind not grouped by newest version: %+v", tw)
+	}
+
+	tests := []struct {
+		term string
+		want string
+	}{
+		{"tw", "tailwind"},
+		{"next", "next-base"},
+		{"login", "auth"}, // description
+		{"utility", "tailwind"},
+	}
+	for _, tt := range tests {
+		got := Search(slices, tt.term)
+		if len(got) == 0 || got[0].ID != tt.want {
+			t.Errorf("Search(%q): got %+v, want %s first", tt.term, got, tt.want)
+		}
+	}
+	if got := Search(slices, "zzz"); len(got) != 0 {
+		t.Errorf("Search(zzz) = %+v, want nothing", got)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Package cache handles local storage and remote resolution of the project manifest.
+compat.go decides whether an addon can be used with a base, from the
+compatibleBases constraints in the addon's manifest entry.
+*/
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+)
+
+// ParseConstraint parses a version constraint of compatibleBases. Besides
+// the range syntax of blang/semver (">=1.2.0 <2.0.0 || 3.x"), terms may use
+// npm's caret and tilde: "^1.2.0" means ">=1.2.0 <2.0.0" and "~1.2.0"
+// ">=1.2.0 <1.3.0". An empty constraint or "*" accepts every version.
+func ParseConstraint(c string) (semver.Range, error) {
+	c = strings.TrimSpace(c)
+	if c == "" || c == "*" {
+		return func(semver.Version) bool { return true }, nil
+	}
+
+	var terms []string
+	for _, f := range strings.Fields(c) {
+		if f[0] != '^' && f[0] != '~' {
+			terms = append(terms, f)
+			continue
+		}
+		v, err := semver.ParseTolerant(f[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in %q: %w", f, err)
+		}
+		upper := semver.Version{Major: v.Major, Minor: v.Minor + 1}
+		if f[0] == '^' {
+			switch {
+			case v.Major > 0:
+				upper = semver.Version{Major: v.Major + 1}
+			case v.Minor > 0:
+				upper = semver.Version{Minor: v.Minor + 1}
+			default:
+				upper = semver.Version{Patch: v.Patch + 1}
+			}
+		}
+		terms = append(terms, ">="+v.String(), "<"+upper.String())
+	}
+	r, err := semver.ParseRange(strings.Join(terms, " "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", c, err)
+	}
+	return r, nil
+}
+
+// CheckCompatible reports whether an addon with the given compatibleBases
+// can be used with baseID at baseVersion. An addon without compatibleBases
+// works with every base. A base version that is not semver only satisfies
+// an empty constraint.
+func CheckCompatible(compatibleBases map[string]string, baseID, baseVersion string) error {
+	if len(compatibleBases) == 0 {
+		return nil
+	}
+
+	constraint, ok := compatibleBases[baseID]
+	if !ok {
+		bases := make([]string, 0, len(compatibleBases))
+		for id := range compatibleBases {
+			bases = append(bases, id)
+		}
+		sort.Strings(bases)
+		return fmt.Errorf("only works with %s", strings.Join(bases, ", "))
+	}
+
+	if c := strings.TrimSpace(constraint); c == "" || c == "*" {
+		return nil
+	}
+	r, err := ParseConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	if baseVersion == "" {
+		baseVersion = "an unversioned base"
+	}
+	v, err := semver.Parse(baseVersion)
+	if err != nil || !r(v) {
+		return fmt.Errorf("needs %s %s, not %s", baseID, constraint, baseVersion)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCompatible(t *testing.T) {
+	tests := []struct {
+		bases   map[string]string
+		id      string
+		version string
+		want    string // substring of the error, empty for compatible
+	}{
+		{nil, "vite-react", "1.0.0", ""},
+		{map[string]string{"next-base": ""}, "next-base", "", ""},
+		{map[string]string{"next-base": "*"}, "next-base", "3.1.0", ""},
+		{map[string]string{"next-base": "^14.1.0"}, "next-base", "14.2.3", ""},
+		{map[string]string{"next-base": "^14.1.0"}, "next-base", "15.0.0", "needs next-base ^14.1.0"},
+		{map[string]string{"next-base": "^0.2.0"}, "next-base", "0.3.0", "needs next-base"},
+		{map[string]string{"next-base": "~1.2.0"}, "next-base", "1.2.9", ""},
+		{map[string]string{"next-base": "~1.2.0"}, "next-base", "1.3.0", "needs next-base"},
+		{map[string]string{"next-base": ">=1.0.0 <2.0.0 || >=3.0.0"}, "next-base", "3.4.0", ""},
+		{map[string]string{"next-base": ">=1.0.0"}, "next-base", "", "an unversioned base"},
+		{map[string]string{"next-base": "", "remix-base": ""}, "vite-react", "1.0.0", "only works with next-base, remix-base"},
+		{map[string]string{"next-base": ">=x"}, "next-base", "1.0.0", "invalid version constraint"},
+	}
+	for _, tt := range tests {
+		err := CheckCompatible(tt.bases, tt.id, tt.version)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("CheckCompatible(%v, %s, %q) = %v, want %q", tt.bases, tt.id, tt.version, err, tt.want)
+		}
+	}
+}
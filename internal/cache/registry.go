@@ -8,22 +8,88 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/004Ongoro/swiftstack/internal/config"
 	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
 )
 
-// GetManifestPath returns the local path to the synced manifest file.
-func GetManifestPath() (string, error) {
+// GetManifestPath returns the local path to the synced manifest of a registry.
+func GetManifestPath(registry string) (string, error) {
 	dir, err := GetCacheDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(dir, "manifest.json"), nil
+	manifests := filepath.Join(dir, "registries")
+	if err := os.MkdirAll(manifests, 0755); err != nil {
+		return "", fmt.Errorf("cache: failed to create registry directory: %w", err)
+	}
+	return filepath.Join(manifests, registry+".json"), nil
 }
 
-// LoadManifest reads the manifest from the local cache.
+// LoadManifest reads the synced manifests of every configured registry and
+// merges them. Registries listed first take priority: once a registry
+// provides a slice id, the same id from later registries is ignored.
+// The local overlay (see LoadLocalOverlay) comes before all of them, so its
+// entries shadow remote slices with the same id.
 func LoadManifest() (*models.RemoteManifest, error) {
-	path, err := GetManifestPath()
+	return loadManifests(true)
+}
+
+// LoadSyncedManifest is LoadManifest without the local overlay: only what
+// the configured registries publish, for copies such as mirrors that must
+// not pick up private or work-in-progress entries.
+func LoadSyncedManifest() (*models.RemoteManifest, error) {
+	return loadManifests(false)
+}
+
+func loadManifests(withOverlay bool) (*models.RemoteManifest, error) {
+	merged := &models.RemoteManifest{}
+	seen := make(map[string]bool)
+
+	if withOverlay {
+		overlay, err := LoadLocalOverlay()
+		if err != nil {
+			return nil, err
+		}
+		overlayPath, _ := config.Get().LocalRegistryPath()
+		merged.Bases = appendUnseen(merged.Bases, overlay.Bases, config.LocalRegistryName, overlayPath, seen)
+		merged.Addons = appendUnseen(merged.Addons, overlay.Addons, config.LocalRegistryName, overlayPath, seen)
+	}
+
+	for _, reg := range config.Get().Registries {
+		m, err := loadRegistryManifest(reg.Name)
+		if err != nil {
+			return nil, err
+		}
+		merged.Bases = appendUnseen(merged.Bases, m.Bases, reg.Name, reg.URL, seen)
+		merged.Addons = appendUnseen(merged.Addons, m.Addons, reg.Name, reg.URL, seen)
+	}
+	return merged, nil
+}
+
+// LoadLocalOverlay reads the hand-maintained overlay manifest
+// (local-registry.json in the config directory by default). 'swiftstack sync'
+// never writes it, so private and work-in-progress entries survive syncs.
+// A missing overlay is an empty manifest.
+func LoadLocalOverlay() (*models.RemoteManifest, error) {
+	path, err := config.Get().LocalRegistryPath()
+	if err != nil {
+		return &models.RemoteManifest{}, nil
+	}
+	m, err := ReadManifestFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("registry: invalid local overlay: %w", err)
+	}
+	return m, nil
+}
+
+// loadRegistryManifest reads a single registry's manifest from the cache and
+// re-verifies its signature, so a tampered cache file is never trusted.
+func loadRegistryManifest(registry string) (*models.RemoteManifest, error) {
+	path, err := GetManifestPath(registry)
 	if err != nil {
 		return nil, err
 	}
@@ -35,45 +101,110 @@ func LoadManifest() (*models.RemoteManifest, error) {
 		return &models.RemoteManifest{}, nil
 	}
 
+	sig, _ := os.ReadFile(path + trust.SignatureExt)
+	if err := trust.VerifyManifest(data, sig); err != nil {
+		return nil, fmt.Errorf("registry: manifest for '%s' failed verification (%v). Run 'swiftstack sync' or check 'swiftstack keys list'", registry, err)
+	}
+
 	var m models.RemoteManifest
 	if err := json.Unmarshal(data, &m); err != nil {
-		return nil, fmt.Errorf("registry: failed to parse manifest: %w", err)
+		return nil, fmt.Errorf("registry: failed to parse manifest for %s: %w", registry, err)
 	}
 	return &m, nil
 }
 
+// appendUnseen adds the entries of src whose ids were not claimed by an
+// earlier registry, tagging each with the registry it came from. Relative
+// slice URLs are resolved against location, where the manifest lives.
+func appendUnseen(dst, src []models.SliceMetadata, registry, location string, seen map[string]bool) []models.SliceMetadata {
+	claimed := make(map[string]bool)
+	for _, s := range src {
+		if seen[s.ID] {
+			continue
+		}
+		s.Registry = registry
+		if u, err := utils.ResolveURL(s.URL, location); err == nil {
+			s.URL = u.String()
+		}
+		dst = append(dst, s)
+		claimed[s.ID] = true
+	}
+	for id := range claimed {
+		seen[id] = true
+	}
+	return dst
+}
+
 // GetAvailableBases is a helper for the UI to get the list of base templates.
 func GetAvailableBases() []models.SliceMetadata {
 	m, _ := LoadManifest()
+	if m == nil {
+		return nil
+	}
 	return m.Bases
 }
 
 // GetAvailableAddons is a helper for the UI to get the list of optional addons.
 func GetAvailableAddons() []models.SliceMetadata {
 	m, _ := LoadManifest()
+	if m == nil {
+		return nil
+	}
 	return m.Addons
 }
 
-// ResolveAlias searches the manifest for a specific slice's URL and Hash.
-func ResolveAlias(alias string) (string, error) {
+// FindSlice returns the manifest entry for an alias. A bare id resolves to
+// its newest version that has not been yanked; id@version selects exactly
+// that version, yanked or not.
+func FindSlice(alias string) (*models.SliceMetadata, error) {
 	m, err := LoadManifest()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return FindInManifest(m, alias)
+}
 
-	// Search in Bases
-	for _, b := range m.Bases {
-		if b.ID == alias {
-			return b.URL, nil
+// FindInManifest resolves an alias against m like FindSlice does against
+// the merged manifest of the configured registries.
+func FindInManifest(m *models.RemoteManifest, alias string) (*models.SliceMetadata, error) {
+	id, version, pinned := strings.Cut(alias, "@")
+	var best *models.SliceMetadata
+	found := false
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for i := range list {
+			s := &list[i]
+			if s.ID != id {
+				continue
+			}
+			found = true
+			if pinned {
+				if s.Version == version {
+					return s, nil
+				}
+				continue
+			}
+			if s.Yanked == nil && (best == nil || compareVersions(s.Version, best.Version) > 0) {
+				best = s
+			}
 		}
 	}
 
-	// Search in Addons
-	for _, a := range m.Addons {
-		if a.ID == alias {
-			return a.URL, nil
-		}
+	switch {
+	case best != nil:
+		return best, nil
+	case found && pinned:
+		return nil, fmt.Errorf("version '%s' of '%s' not found in registry. Try running 'swiftstack sync'", version, id)
+	case found:
+		return nil, fmt.Errorf("every version of '%s' has been yanked; pin one as %s@<version> to use it anyway", id, id)
 	}
+	return nil, fmt.Errorf("alias '%s' not found in registry. Try running 'swiftstack sync'", alias)
+}
 
-	return "", fmt.Errorf("alias '%s' not found in registry. Try running 'swiftstack sync'", alias)
+// ResolveAlias searches the manifest for a specific slice's URL.
+func ResolveAlias(alias string) (string, error) {
+	s, err := FindSlice(alias)
+	if err != nil {
+		return "", err
+	}
+	return s.URL, nil
 }
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+)
+
+func TestLoadManifestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir) // keep the user's keyring out of the test
+
+	cfg := config.Default()
+	cfg.CacheDir = filepath.Join(dir, "cache")
+	cfg.LocalRegistry = filepath.Join(dir, "local-registry.json")
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+
+	remote, err := GetManifestPath("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(path, data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(remote, `{"bases":[{"id":"next-base","version":"1.0.0","url":"https://r/next"}],
+		"addons":[{"id":"tailwind","version":"2.0.0","url":"https://r/tw"}]}`)
+
+	// Without an overlay only the synced registry is listed
+	m, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Bases) != 1 || len(m.Addons) != 1 {
+		t.Fatalf("got %+v", m)
+	}
+
+	write(cfg.LocalRegistry, `{"addons":[{"id":"tailwind","version":"3.0.0-dev","url":"https://l/tw"},
+		{"id":"private-auth","version":"0.1.0","url":"https://l/auth"}]}`)
+
+	m, err = LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Addons) != 2 {
+		t.Fatalf("expected the overlay to shadow tailwind, got %+v", m.Addons)
+	}
+	s, err := FindSlice("tailwind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version != "3.0.0-dev" || s.Registry != config.LocalRegistryName {
+		t.Errorf("tailwind resolved to %+v, want the overlay entry", s)
+	}
+	if s, _ := FindSlice("next-base"); s == nil || s.Registry != "default" {
+		t.Errorf("next-base should still come from the synced registry, got %+v", s)
+	}
+
+	// Mirrors copy only what the registries publish, shadowed entries included
+	synced, err := LoadSyncedManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(synced.Addons) != 1 || synced.Addons[0].Version != "2.0.0" || synced.Addons[0].Registry != "default" {
+		t.Errorf("synced addons = %+v, want only the registry's tailwind@2.0.0", synced.Addons)
+	}
+
+	write(cfg.LocalRegistry, `{not json`)
+	if _, err := LoadManifest(); err == nil {
+		t.Error("expected an error for a malformed overlay")
+	}
+}
+
+func TestFindSliceLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	cfg := config.Default()
+	cfg.CacheDir = filepath.Join(dir, "cache")
+	cfg.LocalRegistry = filepath.Join(dir, "local-registry.json")
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+
+	remote, err := GetManifestPath("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(remote, []byte(`{"bases":[],"addons":[
+		{"id":"auth","version":"1.0.0","url":"https://r/a1","deprecated":{"message":"use 1.2"}},
+		{"id":"auth","version":"1.2.0","url":"https://r/a12"},
+		{"id":"auth","version":"1.3.0","url":"https://r/a13","yanked":{"reason":"leaks sessions"}},
+		{"id":"legacy","version":"0.1.0","url":"https://r/l","yanked":{}}]}`), 0644)
+
+	tests := []struct {
+		alias   string
+		version string
+		err     string
+	}{
+		{"auth", "1.2.0", ""},
+		{"auth@1.3.0", "1.3.0", ""},
+		{"auth@1.0.0", "1.0.0", ""},
+		{"auth@9.9.9", "", "version '9.9.9'"},
+		{"legacy", "", "pin one as legacy@<version>"},
+		{"legacy@0.1.0", "0.1.0", ""},
+	}
+	for _, tt := range tests {
+		s, err := FindSlice(tt.alias)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("FindSlice(%q) error = %v, want %q", tt.alias, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("FindSlice(%q): %v", tt.alias, err)
+		case s.Version != tt.version:
+			t.Errorf("FindSlice(%q) = %s, want %s", tt.alias, s.Version, tt.version)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+/*
+Package cache handles local storage and remote resolution of the project manifest.
+store.go lists, verifies and evicts what is stored in the cache directory:
+slice blobs (with their refs and signatures), their extracted trees, the mirrors of git
+repositories under git/, and archives left by the former id@version.tar.zst
+layout. The last use of an entry is its modification time, refreshed by
+Touch whenever a project uses it.
+*/
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/archiver"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// Kinds of cache entries.
+const (
+	EntrySlice  = "slice"
+	EntryTree   = "tree" // a slice blob extracted, for materialize modes other than extract
+	EntryGit    = "git"
+	EntryLegacy = "legacy" // id@version.tar.zst from before the blob store
+)
+
+const sliceExt = ".tar.zst"
+
+// legacySidecars are the files stored next to a legacy slice archive.
+var legacySidecars = []string{trust.SignatureExt, ".git.json"}
+
+// Entry is one item in the cache. A slice entry is a blob, which may be
+// referenced by several id@version refs.
+type Entry struct {
+	Name     string    `json:"name"` // the refs, the git mirror directory or the legacy file name
+	Kind     string    `json:"kind"`
+	Path     string    `json:"path"`
+	Hash     string    `json:"hash,omitempty"`
+	Refs     []string  `json:"refs,omitempty"`
+	Size     int64     `json:"size"` // including the signature
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// Touch records that a cached file or directory was just used.
+func Touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// refsByHash reads every ref and groups the id@version names by blob.
+func refsByHash(dir string) map[string][]string {
+	out := make(map[string][]string)
+	files, _ := os.ReadDir(filepath.Join(dir, refDir))
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok || f.IsDir() {
+			continue
+		}
+		var ref Ref
+		data, err := os.ReadFile(filepath.Join(dir, refDir, f.Name()))
+		if err != nil || json.Unmarshal(data, &ref) != nil {
+			continue
+		}
+		out[ref.Hash] = append(out[ref.Hash], name)
+	}
+	return out
+}
+
+// List returns every entry in the cache, least recently used first.
+func List() ([]Entry, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	refs := refsByHash(dir)
+	blobs, _ := os.ReadDir(filepath.Join(dir, filepath.FromSlash(blobDir)))
+	for _, b := range blobs {
+		hash := b.Name()
+		info, err := b.Info()
+		if err != nil || b.IsDir() || len(hash) != 64 {
+			continue
+		}
+		e := Entry{
+			Name:     hash[:12],
+			Kind:     EntrySlice,
+			Path:     filepath.Join(dir, filepath.FromSlash(blobDir), hash),
+			Hash:     hash,
+			Refs:     refs[hash],
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		}
+		sort.Strings(e.Refs)
+		if len(e.Refs) > 0 {
+			e.Name = strings.Join(e.Refs, ", ")
+		}
+		if info, err := os.Stat(e.Path + trust.SignatureExt); err == nil {
+			e.Size += info.Size()
+		}
+		entries = append(entries, e)
+	}
+
+	trees, _ := os.ReadDir(filepath.Join(dir, treeDir))
+	for _, t := range trees {
+		hash := t.Name()
+		info, err := t.Info()
+		if err != nil || !t.IsDir() || len(hash) != 64 {
+			continue
+		}
+		e := Entry{
+			Name:     hash[:12],
+			Kind:     EntryTree,
+			Path:     filepath.Join(dir, treeDir, hash),
+			Hash:     hash,
+			Refs:     refs[hash],
+			LastUsed: info.ModTime(),
+		}
+		sort.Strings(e.Refs)
+		if len(e.Refs) > 0 {
+			e.Name = strings.Join(e.Refs, ", ")
+		}
+		e.Size = dirSize(e.Path)
+		entries = append(entries, e)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, sliceExt) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		e := Entry{
+			Name:     strings.TrimSuffix(name, sliceExt),
+			Kind:     EntryLegacy,
+			Path:     filepath.Join(dir, name),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		}
+		for _, ext := range legacySidecars {
+			if info, err := os.Stat(e.Path + ext); err == nil {
+				e.Size += info.Size()
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	repos, _ := os.ReadDir(filepath.Join(dir, "git"))
+	for _, r := range repos {
+		if !r.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, "git", r.Name())
+		info, err := r.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:     r.Name(),
+			Kind:     EntryGit,
+			Path:     path,
+			Size:     dirSize(path),
+			LastUsed: info.ModTime(),
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// ErrInUse is returned by Remove for an entry another process is writing
+// or reading.
+var ErrInUse = errors.New("cache: entry is in use by another process")
+
+// lockKey is the key of the lock that guards an entry, as taken by Lock
+// and RLock while it is written or read. Legacy files have none.
+func lockKey(e Entry) string {
+	switch e.Kind {
+	case EntrySlice:
+		return "sha256-" + e.Hash
+	case EntryTree:
+		return "tree-" + e.Hash
+	case EntryGit:
+		return "git-mirror-" + filepath.Base(e.Path)
+	}
+	return ""
+}
+
+// Remove deletes an entry: a blob with its signature and every ref to it,
+// an extracted tree, a legacy file with its sidecars, or a git mirror. An
+// entry whose lock another process holds is left alone and ErrInUse is
+// returned, rather than waiting or deleting files being read.
+func Remove(e Entry) error {
+	if key := lockKey(e); key != "" {
+		path, err := lockPath(key)
+		if err != nil {
+			return err
+		}
+		lock, err := utils.TryLock(path)
+		if errors.Is(err, utils.ErrLocked) {
+			return fmt.Errorf("%w: %s", ErrInUse, e.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		defer lock.Unlock()
+	}
+
+	var paths []string
+	switch e.Kind {
+	case EntryGit, EntryTree:
+		if err := os.RemoveAll(e.Path); err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		return nil
+	case EntryLegacy:
+		paths = []string{e.Path}
+		for _, ext := range legacySidecars {
+			paths = append(paths, e.Path+ext)
+		}
+	default:
+		dir, err := GetCacheDir()
+		if err != nil {
+			return err
+		}
+		// Refs go first, so no ref is ever left pointing at a missing blob
+		for _, name := range e.Refs {
+			paths = append(paths, filepath.Join(dir, refDir, name+".json"))
+		}
+		paths = append(paths, e.Path+trust.SignatureExt, e.Path)
+	}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// PruneOptions are the limits Prune enforces. Zero values mean no limit.
+type PruneOptions struct {
+	MaxSize int64
+	MaxAge  time.Duration
+	DryRun  bool // report what would be removed without removing it
+}
+
+// ConfiguredLimits returns the cacheLimits of the configuration as
+// PruneOptions.
+func ConfiguredLimits() (PruneOptions, error) {
+	l := config.Get().CacheLimits
+	size, err := config.ParseSize(l.MaxSize)
+	if err != nil {
+		return PruneOptions{}, fmt.Errorf("cache: %w", err)
+	}
+	age, err := config.ParseAge(l.MaxAge)
+	if err != nil {
+		return PruneOptions{}, fmt.Errorf("cache: %w", err)
+	}
+	return PruneOptions{MaxSize: size, MaxAge: age}, nil
+}
+
+// staleTemp is how long a download may sit in tmp/ untouched before Prune
+// assumes its process died.
+const staleTemp = 24 * time.Hour
+
+// Prune evicts entries unused for longer than MaxAge, then the least
+// recently used ones until the cache fits in MaxSize. It returns the
+// evicted entries. Entries in use by another process are skipped.
+// Downloads abandoned by crashed runs are deleted too.
+func Prune(opts PruneOptions) ([]Entry, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		removeStaleTemp()
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	cutoff := time.Now().Add(-opts.MaxAge)
+
+	var evicted []Entry
+	for _, e := range entries { // least recently used first
+		tooOld := opts.MaxAge > 0 && e.LastUsed.Before(cutoff)
+		tooBig := opts.MaxSize > 0 && total > opts.MaxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if !opts.DryRun {
+			err := Remove(e)
+			if errors.Is(err, ErrInUse) {
+				continue
+			}
+			if err != nil {
+				return evicted, err
+			}
+		}
+		total -= e.Size
+		evicted = append(evicted, e)
+	}
+	return evicted, nil
+}
+
+func removeStaleTemp() {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, "tmp"))
+	for _, f := range files {
+		if info, err := f.Info(); err == nil && time.Since(info.ModTime()) > staleTemp {
+			os.RemoveAll(filepath.Join(dir, "tmp", f.Name()))
+		}
+	}
+}
+
+// Verification states reported by Verify.
+const (
+	VerifyOK      = "ok"
+	VerifyCorrupt = "corrupt"
+	VerifyUnknown = "unknown" // not listed by any registry, or a tree whose blob is gone
+)
+
+// VerifyResult is the outcome of re-hashing one cached slice.
+type VerifyResult struct {
+	Entry  Entry
+	Status string
+	Err    error // why a corrupt entry failed, or a tree could not be checked
+}
+
+// Verify re-hashes every cached slice. A blob must still match the hash it
+// is stored under; legacy files are checked against the hash the manifest
+// lists for their id and version. Blobs that no listed version (or git
+// source) refers to are reported as unknown. Extracted trees are compared
+// with the blob they were extracted from, which catches project files
+// hardlinked to the tree and edited in place.
+func Verify(m *models.RemoteManifest) ([]VerifyResult, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]string) // id@version -> hash
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			listed[refName(s.ID, s.Version)] = s.Hash
+		}
+	}
+
+	var results []VerifyResult
+	for _, e := range entries {
+		r := VerifyResult{Entry: e, Status: VerifyUnknown}
+		switch e.Kind {
+		case EntrySlice:
+			for _, ref := range e.Refs {
+				if listed[ref] == e.Hash || strings.Contains(ref, "@git-") {
+					r.Status = VerifyOK
+				}
+			}
+			if err := utils.VerifyFileHash(e.Path, e.Hash); err != nil {
+				r.Status, r.Err = VerifyCorrupt, err
+			}
+		case EntryTree:
+			r.Status, r.Err = verifyTree(e)
+		case EntryLegacy:
+			if want := listed[e.Name]; want != "" {
+				r.Status = VerifyOK
+				if r.Err = utils.VerifyFileHash(e.Path, want); r.Err != nil {
+					r.Status = VerifyCorrupt
+				}
+			}
+		default:
+			continue
+		}
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Entry.Name < results[j].Entry.Name })
+	return results, nil
+}
+
+// verifyTree compares an extracted tree with its blob. A tree whose blob
+// was evicted cannot be checked and is reported as unknown.
+func verifyTree(e Entry) (string, error) {
+	path, err := BlobPath(e.Hash)
+	if err != nil {
+		return VerifyCorrupt, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return VerifyUnknown, errors.New("cache: the slice it was extracted from is no longer cached")
+	}
+	defer f.Close()
+	if err := archiver.Compare(f, e.Path); err != nil {
+		return VerifyCorrupt, fmt.Errorf("cache: extracted tree differs from its slice: %w", err)
+	}
+	return VerifyOK, nil
+}
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/archiver"
+	"github.com/004Ongoro/swiftstack/internal/builder"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// storeSlice puts data into the blob store and points the refs at it.
+func storeSlice(t *testing.T, data string, refs ...string) string {
+	t.Helper()
+	tmp, err := TempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(tmp, []byte(data), 0644)
+	hash, _ := utils.HashFile(tmp)
+	if _, err := StoreBlob(tmp, hash); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range refs {
+		id, version, _ := strings.Cut(r, "@")
+		if err := WriteRef(id, version, Ref{Hash: hash}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return hash
+}
+
+func useCacheDir(t *testing.T) string {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.CacheDir = dir
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+	return dir
+}
+
+func TestPrune(t *testing.T) {
+	useCacheDir(t)
+
+	// Four 100-byte slices, last used 40, 20, 10 and 0 days ago
+	now := time.Now()
+	add := func(ref string, age time.Duration) {
+		path, _ := BlobPath(storeSlice(t, ref+strings.Repeat(".", 100-len(ref)), ref))
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+	reset := func() {
+		add("old@1.0.0", 40*24*time.Hour)
+		add("tailwind@2.0.0", 20*24*time.Hour)
+		add("auth@1.0.0", 10*24*time.Hour)
+		add("next-base@1.0.0", 0)
+	}
+
+	tests := []struct {
+		name string
+		opts PruneOptions
+		want string // evicted entries, least recently used first
+	}{
+		{"no limits", PruneOptions{}, ""},
+		{"max age", PruneOptions{MaxAge: 15 * 24 * time.Hour}, "old@1.0.0,tailwind@2.0.0"},
+		{"size budget", PruneOptions{MaxSize: 250}, "old@1.0.0,tailwind@2.0.0"},
+		{"both", PruneOptions{MaxSize: 150, MaxAge: 30 * 24 * time.Hour}, "old@1.0.0,tailwind@2.0.0,auth@1.0.0"},
+		{"dry run", PruneOptions{MaxSize: 1, DryRun: true}, "old@1.0.0,tailwind@2.0.0,auth@1.0.0,next-base@1.0.0"},
+	}
+	for _, tt := range tests {
+		reset()
+		evicted, err := Prune(tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range evicted {
+			names = append(names, e.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("%s: evicted %q, want %q", tt.name, got, tt.want)
+		}
+		left, _ := List()
+		if want := 4 - len(evicted); !tt.opts.DryRun && len(left) != want {
+			t.Errorf("%s: %d entries left, want %d", tt.name, len(left), want)
+		}
+		for _, e := range evicted {
+			id, version, _ := strings.Cut(e.Refs[0], "@")
+			if _, err := ReadRef(id, version); !tt.opts.DryRun && err == nil {
+				t.Errorf("%s: ref %s survived its blob", tt.name, e.Refs[0])
+			}
+		}
+	}
+
+	// Touched entries become the most recently used
+	reset()
+	path, _ := BlobPath(storeSlice(t, "old@1.0.0"+strings.Repeat(".", 91), "old@1.0.0"))
+	Touch(path)
+	if evicted, _ := Prune(PruneOptions{MaxSize: 300}); len(evicted) != 1 || evicted[0].Name != "tailwind@2.0.0" {
+		t.Errorf("after Touch, evicted %+v; want tailwind@2.0.0", evicted)
+	}
+
+	// Entries locked by a reader are skipped, not waited for
+	reset()
+	hash := storeSlice(t, "old@1.0.0"+strings.Repeat(".", 91), "old@1.0.0")
+	lock, err := RLock("sha256-"+hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted, err := Prune(PruneOptions{MaxSize: 1})
+	lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 3 || !HasBlob(hash) {
+		t.Errorf("with old@1.0.0 in use, evicted %+v; want the other three", evicted)
+	}
+}
+
+func TestBlobStore(t *testing.T) {
+	dir := useCacheDir(t)
+
+	// The same content under two versions is stored once
+	hash := storeSlice(t, "tailwind", "tailwind@2.0.0", "tailwind@2.0.1")
+	if again := storeSlice(t, "tailwind", "tw@1.0.0"); again != hash {
+		t.Fatalf("hash changed: %s != %s", again, hash)
+	}
+	entries, _ := List()
+	if len(entries) != 1 || entries[0].Name != "tailwind@2.0.0, tailwind@2.0.1, tw@1.0.0" {
+		t.Fatalf("List() = %+v, want one blob with three refs", entries)
+	}
+	if _, err := BlobPath("../../etc/passwd"); err == nil {
+		t.Error("BlobPath accepted a path instead of a hash")
+	}
+
+	// Verify re-hashes blobs and checks legacy files against the manifest
+	corrupt := storeSlice(t, "auth", "auth@1.0.0")
+	path, _ := BlobPath(corrupt)
+	os.WriteFile(path, []byte("tampered"), 0644)
+	storeSlice(t, "gone", "gone@0.1.0")
+	storeSlice(t, "packed", "lib@git-abc")
+	os.WriteFile(filepath.Join(dir, "legacy@1.0.0.tar.zst"), []byte("legacy"), 0644)
+	legacyHash, _ := utils.HashFile(filepath.Join(dir, "legacy@1.0.0.tar.zst"))
+
+	m := &models.RemoteManifest{Addons: []models.SliceMetadata{
+		{ID: "tailwind", Version: "2.0.0", Hash: hash},
+		{ID: "auth", Version: "1.0.0", Hash: corrupt},
+		{ID: "legacy", Version: "1.0.0", Hash: legacyHash},
+	}}
+	results, err := Verify(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Entry.Name+"="+r.Status)
+	}
+	want := "auth@1.0.0=corrupt,gone@0.1.0=unknown,legacy@1.0.0=ok,lib@git-abc=ok,tailwind@2.0.0, tailwind@2.0.1, tw@1.0.0=ok"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Verify = %v, want %s", got, want)
+	}
+}
+
+func TestVerifyTrees(t *testing.T) {
+	useCacheDir(t)
+
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "node_modules", "react"), 0755)
+	os.WriteFile(filepath.Join(src, "package.json"), []byte(`{"name":"app"}`), 0644)
+	os.WriteFile(filepath.Join(src, "node_modules", "react", "index.js"), []byte("react"), 0644)
+	tmp, _ := TempPath()
+	if err := builder.CreateSlice(src, tmp); err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := utils.HashFile(tmp)
+	blob, err := StoreBlob(tmp, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extract := func() string {
+		f, _ := os.Open(blob)
+		defer f.Close()
+		dir, _ := TempDir()
+		if err := archiver.Extract(f, dir); err != nil {
+			t.Fatal(err)
+		}
+		tree, _ := TreePath(hash)
+		os.RemoveAll(tree)
+		if _, err := StoreTree(dir, hash); err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+
+	tests := []struct {
+		name   string
+		change func(tree string)
+		want   string
+	}{
+		{"intact", func(string) {}, VerifyOK},
+		{"edited", func(tree string) {
+			os.WriteFile(filepath.Join(tree, "node_modules", "react", "index.js"), []byte("patched"), 0644)
+		}, VerifyCorrupt},
+		{"missing file", func(tree string) { os.Remove(filepath.Join(tree, "package.json")) }, VerifyCorrupt},
+		{"extra file", func(tree string) { os.WriteFile(filepath.Join(tree, "extra.js"), nil, 0644) }, VerifyCorrupt},
+		{"blob evicted", func(string) { os.Rename(blob, blob+".bak") }, VerifyUnknown},
+	}
+	for _, tt := range tests {
+		tt.change(extract())
+		results, err := Verify(&models.RemoteManifest{})
+		os.Rename(blob+".bak", blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for _, r := range results {
+			if r.Entry.Kind == EntryTree {
+				got = r.Status
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: tree is %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,609 @@
+/*
+Package config loads SwiftStack settings from the user config file, an
+optional project-level override and SWIFTSTACK_* environment variables.
+
+Precedence (lowest to highest):
+ 1. Built-in defaults
+ 2. User file:    <os.UserConfigDir()>/swiftstack/config.yaml
+ 3. Project file: the nearest .swiftstack.yaml in the working directory or its parents
+ 4. Environment variables
+*/
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRegistryURL is the manifest used when no registry is configured.
+const DefaultRegistryURL = "https://raw.githubusercontent.com/004Ongoro/swiftstack/main/registry.json"
+
+// ProjectFileName is the name of the project-level override file.
+const ProjectFileName = ".swiftstack.yaml"
+
+// Supported package managers for the final lockfile update.
+var PackageManagers = []string{"npm", "pnpm", "yarn", "bun"}
+
+// Supported policies for files that an addon and the base both provide.
+var ConflictPolicies = []string{"backup", "overwrite", "skip", "fail"}
+
+// Ways of writing a slice into a project. extract decompresses its archive
+// every time; the others keep each slice extracted in the cache and clone
+// that tree: auto by reflink, else by hardlinking node_modules, else by
+// copying; reflink never hardlinks; copy always copies.
+var MaterializeModes = []string{"extract", "auto", "reflink", "copy"}
+
+// Slice signature policies for a registry.
+const (
+	SignaturesOff     = "off"     // never check slice signatures (default)
+	SignaturesWarn    = "warn"    // check when a signature exists, warn on problems
+	SignaturesRequire = "require" // refuse slices without a valid signature
+)
+
+// Registry is a named remote manifest.
+type Registry struct {
+	Name  string      `yaml:"name"`
+	URL   string      `yaml:"url"`
+	Trust TrustPolicy `yaml:"trust,omitempty"`
+}
+
+// TrustPolicy controls how detached slice signatures from a registry are checked.
+type TrustPolicy struct {
+	// Slices is one of SignaturesOff, SignaturesWarn or SignaturesRequire.
+	Slices string `yaml:"slices,omitempty"`
+	// Signers restricts which keyring entries may sign this registry's slices.
+	// Empty means any trusted key.
+	Signers []string `yaml:"signers,omitempty"`
+}
+
+// SliceMode returns the effective signature policy, defaulting to off.
+func (t TrustPolicy) SliceMode() string {
+	if t.Slices == "" {
+		return SignaturesOff
+	}
+	return t.Slices
+}
+
+// HostAuth holds the credentials sent to one host. A token is sent as a
+// bearer token; otherwise username and password are sent as basic auth.
+type HostAuth struct {
+	Token    string `yaml:"token,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// Empty reports whether no credentials are set.
+func (a HostAuth) Empty() bool {
+	return a.Token == "" && a.Username == "" && a.Password == ""
+}
+
+// CacheLimits bounds the slice cache. 'swiftstack cache prune' enforces
+// them, and so does 'swiftstack create' after every project when any is set.
+type CacheLimits struct {
+	// MaxSize is a size such as "2GB" or "500MiB"; least recently used
+	// entries are evicted until the cache fits.
+	MaxSize string `yaml:"maxSize,omitempty"`
+	// MaxAge is a duration such as "720h", "30d" or "2w"; entries unused
+	// for longer are evicted.
+	MaxAge string `yaml:"maxAge,omitempty"`
+}
+
+// Empty reports whether no limit is set.
+func (l CacheLimits) Empty() bool {
+	return l.MaxSize == "" && l.MaxAge == ""
+}
+
+// Supported targets for 'swiftstack publish'.
+var PublishBackends = []string{"local", "http", "s3", "oci", "server"}
+
+// PublishConfig describes where 'swiftstack publish' uploads slices and
+// which manifest it updates.
+type PublishConfig struct {
+	Backend string `yaml:"backend,omitempty"` // local, http, s3, oci or server
+	// Path is the registry directory for the local backend.
+	Path string `yaml:"path,omitempty"`
+	// URL is the base URL accepting PUT (http), the S3 endpoint (s3), the
+	// oci://host/prefix repository prefix (oci) or the address of a
+	// 'swiftstack registry serve' instance (server).
+	URL    string `yaml:"url,omitempty"`
+	Bucket string `yaml:"bucket,omitempty"`
+	Region string `yaml:"region,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+	// Manifest is the manifest object name, registry.json by default.
+	Manifest string `yaml:"manifest,omitempty"`
+	// PublicURL prefixes slice URLs written into the manifest. When empty,
+	// the upload location is used (a bare file name for the local backend).
+	PublicURL string `yaml:"publicUrl,omitempty"`
+}
+
+// Config holds every user-tunable setting.
+type Config struct {
+	Registries     []Registry    `yaml:"registries,omitempty"`
+	CacheDir       string        `yaml:"cacheDir,omitempty"`
+	CacheLimits    CacheLimits   `yaml:"cacheLimits,omitempty"`
+	Chunks         int           `yaml:"chunks,omitempty"`
+	PackageManager string        `yaml:"packageManager,omitempty"`
+	ConflictPolicy string        `yaml:"conflictPolicy,omitempty"`
+	OutputPath     string        `yaml:"outputPath,omitempty"`
+	Materialize    string        `yaml:"materialize,omitempty"` // one of MaterializeModes
+	Publish        PublishConfig `yaml:"publish,omitempty"`
+	// LocalRegistry is the overlay manifest merged on top of the synced
+	// registries (default: local-registry.json next to config.yaml).
+	LocalRegistry string `yaml:"localRegistry,omitempty"`
+	// Auth maps a host (with its port, if not the default) to credentials
+	// for registry and slice downloads.
+	Auth map[string]HostAuth `yaml:"auth,omitempty"`
+	// AllowProjectTrust lets a project's .swiftstack.yaml set the trust
+	// policy of registries. Only honored in the user config file.
+	AllowProjectTrust bool `yaml:"allowProjectTrust,omitempty"`
+}
+
+// LocalRegistryName is the registry name given to entries of the local overlay.
+const LocalRegistryName = "local"
+
+// Default returns the settings SwiftStack uses when nothing is configured.
+func Default() *Config {
+	return &Config{
+		Registries:     []Registry{{Name: "default", URL: DefaultRegistryURL}},
+		Chunks:         4,
+		PackageManager: "npm",
+		ConflictPolicy: "backup",
+		OutputPath:     ".",
+		Materialize:    "extract",
+	}
+}
+
+var (
+	current *Config
+	mu      sync.Mutex
+	warned  bool // whether Get reported a load error yet
+)
+
+// Get returns the active configuration, loading it on first use.
+// If loading fails the defaults are returned and the error is printed to
+// stderr once; call Load to handle it instead.
+func Get() *Config {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		c, err := load()
+		if err != nil {
+			if !warned {
+				fmt.Fprintf(os.Stderr, "Warning: %v; using the default settings\n", err)
+				warned = true
+			}
+			c = Default()
+		}
+		current = c
+	}
+	return current
+}
+
+// Set replaces the active configuration (used by tests and flag overrides).
+func Set(c *Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = c
+}
+
+// Load reads all configuration sources, makes the result active and returns it.
+func Load() (*Config, error) {
+	c, err := load()
+	if err != nil {
+		return nil, err
+	}
+	Set(c)
+	return c, nil
+}
+
+func load() (*Config, error) {
+	c := Default()
+
+	if path, err := UserConfigPath(); err == nil {
+		if err := c.mergeFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		if path := FindProjectConfig(wd); path != "" {
+			// A cloned repository must not relax the checks the user set up
+			user, allow := c.Registries, c.AllowProjectTrust
+			if err := c.mergeFile(path); err != nil {
+				return nil, err
+			}
+			c.AllowProjectTrust = allow
+			if !allow {
+				c.keepTrust(user)
+			}
+		}
+	}
+
+	if err := c.mergeEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Dir returns the SwiftStack user configuration directory.
+// It is not created; callers that write into it must MkdirAll first.
+func Dir() (string, error) {
+	parent, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("config: could not determine user config dir: %w", err)
+	}
+	return filepath.Join(parent, "swiftstack"), nil
+}
+
+// UserConfigPath returns the location of the user-level config.yaml.
+func UserConfigPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// LocalRegistryPath returns the overlay manifest merged on top of the
+// synced registries. The file does not have to exist.
+func (c *Config) LocalRegistryPath() (string, error) {
+	if c.LocalRegistry != "" {
+		return c.LocalRegistry, nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "local-registry.json"), nil
+}
+
+// FindProjectConfig walks up from start looking for a .swiftstack.yaml file.
+// It returns an empty string if none is found.
+func FindProjectConfig(start string) string {
+	dir := start
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// mergeFile overlays the non-empty settings of a YAML file onto c.
+// A missing file is not an error.
+func (c *Config) mergeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config: failed to read %s: %w", path, err)
+	}
+
+	var fc Config
+	if err := yaml.Unmarshal(data, &fc); err != nil {
+		return fmt.Errorf("config: failed to parse %s: %w", path, err)
+	}
+
+	// Relative directories are resolved against the file that set them.
+	if fc.CacheDir != "" {
+		fc.CacheDir = resolvePath(fc.CacheDir, filepath.Dir(path))
+	}
+	if fc.Publish.Path != "" {
+		fc.Publish.Path = resolvePath(fc.Publish.Path, filepath.Dir(path))
+	}
+	if fc.LocalRegistry != "" {
+		fc.LocalRegistry = resolvePath(fc.LocalRegistry, filepath.Dir(path))
+	}
+	for i, r := range fc.Registries {
+		// A registry may be a manifest on disk (a shared drive, a git checkout)
+		if r.URL != "" && !strings.Contains(r.URL, ":") {
+			fc.Registries[i].URL = resolvePath(r.URL, filepath.Dir(path))
+		}
+	}
+	c.merge(&fc)
+	return nil
+}
+
+// keepTrust gives every registry the trust policy of the registry in user
+// with the same name or URL, and none otherwise, discarding the policies
+// set by a project file.
+func (c *Config) keepTrust(user []Registry) {
+	for i, r := range c.Registries {
+		c.Registries[i].Trust = TrustPolicy{}
+		for _, u := range user {
+			if u.Name == r.Name || u.URL == r.URL {
+				c.Registries[i].Trust = u.Trust
+				break
+			}
+		}
+	}
+}
+
+func (c *Config) merge(o *Config) {
+	if len(o.Registries) > 0 {
+		c.Registries = o.Registries
+	}
+	if o.CacheDir != "" {
+		c.CacheDir = o.CacheDir
+	}
+	if o.CacheLimits.MaxSize != "" {
+		c.CacheLimits.MaxSize = o.CacheLimits.MaxSize
+	}
+	if o.CacheLimits.MaxAge != "" {
+		c.CacheLimits.MaxAge = o.CacheLimits.MaxAge
+	}
+	if o.Chunks != 0 {
+		c.Chunks = o.Chunks
+	}
+	if o.PackageManager != "" {
+		c.PackageManager = o.PackageManager
+	}
+	if o.ConflictPolicy != "" {
+		c.ConflictPolicy = o.ConflictPolicy
+	}
+	if o.OutputPath != "" {
+		c.OutputPath = o.OutputPath
+	}
+	if o.Materialize != "" {
+		c.Materialize = o.Materialize
+	}
+	if o.Publish.Backend != "" {
+		c.Publish = o.Publish
+	}
+	if o.LocalRegistry != "" {
+		c.LocalRegistry = o.LocalRegistry
+	}
+	if o.AllowProjectTrust {
+		c.AllowProjectTrust = true
+	}
+	for host, a := range o.Auth {
+		if c.Auth == nil {
+			c.Auth = make(map[string]HostAuth)
+		}
+		c.Auth[strings.ToLower(host)] = a
+	}
+}
+
+// mergeEnv applies SWIFTSTACK_* environment variables.
+//
+// SWIFTSTACK_REGISTRIES is a comma-separated list of "name=url" or bare URLs.
+func (c *Config) mergeEnv() error {
+	if v := os.Getenv("SWIFTSTACK_REGISTRIES"); v != "" {
+		regs, err := parseRegistryList(v)
+		if err != nil {
+			return err
+		}
+		c.Registries = regs
+	}
+	if v := os.Getenv("SWIFTSTACK_CACHE_DIR"); v != "" {
+		c.CacheDir = resolvePath(v, "")
+	}
+	if v := os.Getenv("SWIFTSTACK_CACHE_MAX_SIZE"); v != "" {
+		c.CacheLimits.MaxSize = v
+	}
+	if v := os.Getenv("SWIFTSTACK_CACHE_MAX_AGE"); v != "" {
+		c.CacheLimits.MaxAge = v
+	}
+	if v := os.Getenv("SWIFTSTACK_CHUNKS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("config: SWIFTSTACK_CHUNKS must be a number, got %q", v)
+		}
+		c.Chunks = n
+	}
+	if v := os.Getenv("SWIFTSTACK_PACKAGE_MANAGER"); v != "" {
+		c.PackageManager = v
+	}
+	if v := os.Getenv("SWIFTSTACK_CONFLICT_POLICY"); v != "" {
+		c.ConflictPolicy = v
+	}
+	if v := os.Getenv("SWIFTSTACK_OUTPUT"); v != "" {
+		c.OutputPath = v
+	}
+	if v := os.Getenv("SWIFTSTACK_MATERIALIZE"); v != "" {
+		c.Materialize = v
+	}
+	if v := os.Getenv("SWIFTSTACK_LOCAL_REGISTRY"); v != "" {
+		c.LocalRegistry = resolvePath(v, "")
+	}
+	return nil
+}
+
+func parseRegistryList(v string) ([]Registry, error) {
+	var regs []Registry
+	for i, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(part, "=")
+		if !ok || strings.Contains(name, ":") {
+			// Bare URL (the "=" may belong to a query string).
+			url = part
+			name = "default"
+			if i > 0 {
+				name = fmt.Sprintf("registry%d", i+1)
+			}
+		}
+		regs = append(regs, Registry{Name: strings.TrimSpace(name), URL: strings.TrimSpace(url)})
+	}
+	if len(regs) == 0 {
+		return nil, fmt.Errorf("config: SWIFTSTACK_REGISTRIES is set but lists no registries")
+	}
+	return regs, nil
+}
+
+// Validate reports settings that SwiftStack cannot act on.
+func (c *Config) Validate() error {
+	if len(c.Registries) == 0 {
+		return fmt.Errorf("config: at least one registry is required")
+	}
+	seen := make(map[string]bool)
+	for _, r := range c.Registries {
+		if r.Name == "" || r.URL == "" {
+			return fmt.Errorf("config: every registry needs a name and a url")
+		}
+		if strings.ContainsAny(r.Name, `/\`) {
+			return fmt.Errorf("config: registry name %q must not contain path separators", r.Name)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("config: duplicate registry name %q", r.Name)
+		}
+		if r.Name == LocalRegistryName {
+			return fmt.Errorf("config: registry name %q is reserved for the local overlay", r.Name)
+		}
+		switch r.Trust.SliceMode() {
+		case SignaturesOff, SignaturesWarn, SignaturesRequire:
+		default:
+			return fmt.Errorf("config: registry %q has unknown trust.slices %q (want off, warn or require)", r.Name, r.Trust.Slices)
+		}
+		seen[r.Name] = true
+	}
+	if _, err := ParseSize(c.CacheLimits.MaxSize); err != nil {
+		return fmt.Errorf("config: cacheLimits.maxSize: %w", err)
+	}
+	if _, err := ParseAge(c.CacheLimits.MaxAge); err != nil {
+		return fmt.Errorf("config: cacheLimits.maxAge: %w", err)
+	}
+	if c.Chunks < 1 {
+		return fmt.Errorf("config: chunks must be at least 1, got %d", c.Chunks)
+	}
+	if !contains(PackageManagers, c.PackageManager) {
+		return fmt.Errorf("config: unsupported package manager %q (want one of %s)",
+			c.PackageManager, strings.Join(PackageManagers, ", "))
+	}
+	if !contains(ConflictPolicies, c.ConflictPolicy) {
+		return fmt.Errorf("config: unsupported conflict policy %q (want one of %s)",
+			c.ConflictPolicy, strings.Join(ConflictPolicies, ", "))
+	}
+	if !contains(MaterializeModes, c.Materialize) {
+		return fmt.Errorf("config: unsupported materialize mode %q (want one of %s)",
+			c.Materialize, strings.Join(MaterializeModes, ", "))
+	}
+	for host, a := range c.Auth {
+		// Never echo the credentials themselves
+		switch {
+		case host == "" || strings.Contains(host, "/"):
+			return fmt.Errorf("config: auth key %q must be a host name, not a URL", host)
+		case a.Token != "" && (a.Username != "" || a.Password != ""):
+			return fmt.Errorf("config: auth for %s sets both a token and a username/password", host)
+		case a.Token == "" && a.Username == "":
+			return fmt.Errorf("config: auth for %s needs a token or a username", host)
+		}
+	}
+	if c.Publish.Backend != "" && !contains(PublishBackends, c.Publish.Backend) {
+		return fmt.Errorf("config: unsupported publish backend %q (want one of %s)",
+			c.Publish.Backend, strings.Join(PublishBackends, ", "))
+	}
+	return nil
+}
+
+// ParseSize parses a byte size such as "512", "500MB" or "1.5GiB". Decimal
+// units (KB, MB, GB, TB) are powers of 1000, binary ones (KiB...) of 1024.
+// An empty string is 0, meaning no limit.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+		{"B", 1},
+	}
+	num, mult := strings.ToUpper(s), 1.0
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 500MB or 2GiB)", s)
+	}
+	return int64(n * mult), nil
+}
+
+// ParseAge parses a duration in Go syntax ("36h") or whole days or weeks
+// ("30d", "2w"). An empty string is 0, meaning no limit.
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 0 {
+		switch s[len(s)-1] {
+		case 'd':
+			return time.Duration(n) * 24 * time.Hour, nil
+		case 'w':
+			return time.Duration(n) * 7 * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q (want e.g. 30d, 2w or 720h)", s)
+	}
+	return d, nil
+}
+
+// Registry returns the registry with the given name, if configured.
+func (c *Config) Registry(name string) (Registry, bool) {
+	for _, r := range c.Registries {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Registry{}, false
+}
+
+// resolvePath expands a leading "~" and makes relative paths absolute against base
+// (or the working directory when base is empty).
+func resolvePath(p, base string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	if base != "" {
+		return filepath.Join(base, p)
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	userDir := t.TempDir()
+	projectDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", userDir)
+	t.Setenv("HOME", userDir)
+	t.Chdir(projectDir)
+
+	writeFile(t, filepath.Join(userDir, "swiftstack", "config.yaml"), `
+registries:
+  - name: corp
+    url: https://registry.example.com/registry.json
+chunks: 8
+packageManager: pnpm
+conflictPolicy: skip
+`)
+	writeFile(t, filepath.Join(projectDir, ProjectFileName), `
+chunks: 2
+cacheDir: .cache
+`)
+	t.Setenv("SWIFTSTACK_PACKAGE_MANAGER", "yarn")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Registries) != 1 || c.Registries[0].Name != "corp" {
+		t.Errorf("Registries = %+v; want the user registry", c.Registries)
+	}
+	if c.Chunks != 2 {
+		t.Errorf("Chunks = %d; want project override 2", c.Chunks)
+	}
+	if c.PackageManager != "yarn" {
+		t.Errorf("PackageManager = %s; want env override yarn", c.PackageManager)
+	}
+	if c.ConflictPolicy != "skip" {
+		t.Errorf("ConflictPolicy = %s; want skip", c.ConflictPolicy)
+	}
+	if want := filepath.Join(projectDir, ".cache"); c.CacheDir != want {
+		t.Errorf("CacheDir = %s; want %s", c.CacheDir, want)
+	}
+	if c.OutputPath != "." {
+		t.Errorf("OutputPath = %s; want default .", c.OutputPath)
+	}
+}
+
+func TestProjectTrust(t *testing.T) {
+	const user = `
+registries:
+  - name: corp
+    url: https://registry.example.com/registry.json
+    trust:
+      slices: require
+`
+	const project = `
+registries:
+  - name: corp
+    url: https://registry.example.com/registry.json
+    trust:
+      slices: "off"
+  - name: extra
+    url: https://extra.example.com/registry.json
+    trust:
+      slices: warn
+allowProjectTrust: true
+`
+	tests := []struct {
+		name      string
+		user      string
+		wantCorp  string
+		wantExtra string
+	}{
+		{"project trust ignored", user, SignaturesRequire, SignaturesOff},
+		{"project trust allowed", user + "allowProjectTrust: true\n", SignaturesOff, SignaturesWarn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userDir := t.TempDir()
+			projectDir := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", userDir)
+			t.Setenv("HOME", userDir)
+			t.Chdir(projectDir)
+			writeFile(t, filepath.Join(userDir, "swiftstack", "config.yaml"), tt.user)
+			writeFile(t, filepath.Join(projectDir, ProjectFileName), project)
+
+			c, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Registries) != 2 {
+				t.Fatalf("Registries = %+v; want the project's two", c.Registries)
+			}
+			if got := c.Registries[0].Trust.SliceMode(); got != tt.wantCorp {
+				t.Errorf("corp trust = %s; want %s", got, tt.wantCorp)
+			}
+			if got := c.Registries[1].Trust.SliceMode(); got != tt.wantExtra {
+				t.Errorf("extra trust = %s; want %s", got, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestParseRegistryList(t *testing.T) {
+	regs, err := parseRegistryList("https://a.example/r.json, mirror=https://b.example/r.json?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != 2 {
+		t.Fatalf("got %d registries; want 2", len(regs))
+	}
+	if regs[0].Name != "default" || regs[0].URL != "https://a.example/r.json" {
+		t.Errorf("first registry = %+v", regs[0])
+	}
+	if regs[1].Name != "mirror" || regs[1].URL != "https://b.example/r.json?x=1" {
+		t.Errorf("second registry = %+v", regs[1])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		ok     bool
+	}{
+		{"defaults", func(c *Config) {}, true},
+		{"zero chunks", func(c *Config) { c.Chunks = 0 }, false},
+		{"unknown manager", func(c *Config) { c.PackageManager = "pip" }, false},
+		{"unknown policy", func(c *Config) { c.ConflictPolicy = "merge" }, false},
+		{"duplicate registry", func(c *Config) { c.Registries = append(c.Registries, c.Registries[0]) }, false},
+		{"reserved registry name", func(c *Config) { c.Registries[0].Name = LocalRegistryName }, false},
+		{"host auth", func(c *Config) { c.Auth = map[string]HostAuth{"cdn.example.com": {Username: "ci", Password: "x"}} }, true},
+		{"auth keyed by url", func(c *Config) { c.Auth = map[string]HostAuth{"https://cdn.example.com": {Token: "t"}} }, false},
+		{"token and password", func(c *Config) { c.Auth = map[string]HostAuth{"cdn.example.com": {Token: "t", Password: "x"}} }, false},
+		{"cache limits", func(c *Config) { c.CacheLimits = CacheLimits{MaxSize: "1.5GiB", MaxAge: "30d"} }, true},
+		{"bad cache size", func(c *Config) { c.CacheLimits.MaxSize = "lots" }, false},
+		{"bad cache age", func(c *Config) { c.CacheLimits.MaxAge = "a month" }, false},
+		{"materialize from trees", func(c *Config) { c.Materialize = "auto" }, true},
+		{"unknown materialize mode", func(c *Config) { c.Materialize = "symlink" }, false},
+	}
+
+	for _, tt := range tests {
+		c := Default()
+		tt.modify(c)
+		if err := c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() error = %v; want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
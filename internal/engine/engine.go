@@ -5,12 +5,19 @@ This version includes SHA-256 integrity verification for all slices.
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/004Ongoro/swiftstack/internal/archiver"
 	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/gitsrc"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
 	"github.com/004Ongoro/swiftstack/internal/utils"
 )
 
@@ -19,9 +26,48 @@ type ProjectOptions struct {
 	OutputPath  string
 	BaseSlice   string
 	AddonSlices []string
+
+	// Chunks is the number of parallel connections used per download.
+	Chunks int
+	// PackageManager refreshes the lockfile once assembly is done (npm, pnpm, yarn, bun).
+	PackageManager string
+	// ConflictPolicy decides what happens when an addon ships a file the
+	// project already has (see utils.MoveWithPolicy).
+	ConflictPolicy string
+	// Materialize is how slices are written into the project (see
+	// config.MaterializeModes).
+	Materialize string
+}
+
+// withDefaults fills unset tuning options from the active configuration.
+func (opts ProjectOptions) withDefaults() ProjectOptions {
+	cfg := config.Get()
+	if opts.OutputPath == "" {
+		opts.OutputPath = cfg.OutputPath
+	}
+	if opts.Chunks < 1 {
+		opts.Chunks = cfg.Chunks
+	}
+	if opts.PackageManager == "" {
+		opts.PackageManager = cfg.PackageManager
+	}
+	if opts.ConflictPolicy == "" {
+		opts.ConflictPolicy = cfg.ConflictPolicy
+	}
+	if opts.Materialize == "" {
+		opts.Materialize = cfg.Materialize
+	}
+	return opts
 }
 
 func GenerateProject(opts ProjectOptions) error {
+	opts = opts.withDefaults()
+
+	// Refuse to touch the filesystem if the local manifest can't be trusted
+	if _, err := cache.LoadManifest(); err != nil {
+		return fmt.Errorf("engine: %w", err)
+	}
+
 	fullPath := filepath.Join(opts.OutputPath, opts.Name)
 	if err := os.MkdirAll(fullPath, 0755); err != nil {
 		return fmt.Errorf("engine: failed to create project dir: %w", err)
@@ -35,23 +81,49 @@ func GenerateProject(opts ProjectOptions) error {
 		}
 	}()
 
-	// 1. Resolve and Verify Slices
-	basePath, err := ensureSlice(opts.BaseSlice)
+	// 1. Resolve, check and verify slices
+	base, err := resolveSlice(opts.BaseSlice)
 	if err != nil {
 		return err
 	}
+	var addons []*models.SliceMetadata
+	for _, alias := range opts.AddonSlices {
+		meta, err := resolveSlice(alias)
+		if err != nil {
+			return err
+		}
+		if err := cache.CheckCompatible(meta.CompatibleBases, base.ID, base.Version); err != nil {
+			return fmt.Errorf("engine: addon '%s' is not compatible with base '%s': %w", meta.ID, base.ID, err)
+		}
+		addons = append(addons, meta)
+	}
+
+	// The slices stay locked until the project is built, so a concurrent
+	// prune or 'cache clean' cannot remove them halfway
+	var locks []*utils.FileLock
+	defer func() {
+		for _, l := range locks {
+			l.Unlock()
+		}
+	}()
+	basePath, lock, err := ensureSlice(base, opts.Chunks)
+	if err != nil {
+		return err
+	}
+	locks = append(locks, lock)
 
 	var addonPaths []string
-	for _, addon := range opts.AddonSlices {
-		path, err := ensureSlice(addon)
+	for _, meta := range addons {
+		path, lock, err := ensureSlice(meta, opts.Chunks)
 		if err != nil {
 			return err
 		}
+		locks = append(locks, lock)
 		addonPaths = append(addonPaths, path)
 	}
 
 	// 2. Extract Base
-	if err := extractSlice(basePath, fullPath); err != nil {
+	if err := placeSlice(basePath, fullPath, opts.Materialize); err != nil {
 		return err
 	}
 
@@ -60,7 +132,7 @@ func GenerateProject(opts ProjectOptions) error {
 		tempAddonDir := filepath.Join(fullPath, ".swiftstack_temp")
 		os.MkdirAll(tempAddonDir, 0755)
 
-		if err := extractSlice(slicePath, tempAddonDir); err != nil {
+		if err := placeSlice(slicePath, tempAddonDir, opts.Materialize); err != nil {
 			os.RemoveAll(tempAddonDir)
 			return err
 		}
@@ -73,71 +145,400 @@ func GenerateProject(opts ProjectOptions) error {
 			os.Remove(slicePkg)
 		}
 
-		utils.MoveWithBackup(tempAddonDir, fullPath)
+		if err := utils.MoveWithPolicy(tempAddonDir, fullPath, opts.ConflictPolicy); err != nil {
+			os.RemoveAll(tempAddonDir)
+			return fmt.Errorf("engine: failed to merge addon files: %w", err)
+		}
 		os.RemoveAll(tempAddonDir)
 	}
 
 	// 4. Finalize
 	fmt.Println("Finalizing project structure...")
-	utils.RunNpmLockUpdate(fullPath)
+	utils.RunLockUpdate(fullPath, opts.PackageManager)
 
 	success = true
+	for _, l := range locks {
+		l.Unlock()
+	}
+	locks = nil
+	pruneCache()
 	return nil
 }
 
-func ensureSlice(alias string) (string, error) {
-	// 1. Resolve metadata from manifest
-	m, err := cache.LoadManifest()
+// pruneCache enforces the configured cache limits, if any. Failing to prune
+// does not fail the project.
+func pruneCache() {
+	if config.Get().CacheLimits.Empty() {
+		return
+	}
+	limits, err := cache.ConfiguredLimits()
+	if err == nil {
+		var evicted []cache.Entry
+		if evicted, err = cache.Prune(limits); err == nil && len(evicted) > 0 {
+			fmt.Printf("Pruned %d cache entries to stay within the configured limits.\n", len(evicted))
+		}
+	}
 	if err != nil {
-		return "", err
+		fmt.Printf("Warning: failed to prune the cache: %v\n", err)
 	}
+}
 
-	var targetHash, url string
-	found := false
-
-	// Search manifest for the hash and URL
-	for _, b := range m.Bases {
-		if b.ID == alias {
-			targetHash = b.Hash
-			url = b.URL
-			found = true
-			break
+// resolveSlice looks up the manifest entry of an alias, or describes a git
+// location given directly instead of one.
+func resolveSlice(alias string) (*models.SliceMetadata, error) {
+	if gitsrc.IsSource(alias) {
+		src, err := gitsrc.Parse(alias)
+		if err != nil {
+			return nil, fmt.Errorf("engine: %w", err)
 		}
+		return &models.SliceMetadata{ID: src.Name(), URL: alias}, nil
 	}
-	if !found {
-		for _, a := range m.Addons {
-			if a.ID == alias {
-				targetHash = a.Hash
-				url = a.URL
-				found = true
-				break
-			}
+
+	meta, err := cache.FindSlice(alias)
+	if err != nil {
+		return nil, fmt.Errorf("engine: %w", err)
+	}
+	warnLifecycle(meta)
+	return meta, nil
+}
+
+// FetchSlice makes sure the slice is in the cache, downloading and verifying
+// it like GenerateProject does, and returns the path of its blob. The blob
+// is kept from eviction until the returned lock is released.
+func FetchSlice(meta *models.SliceMetadata, chunks int) (string, *utils.FileLock, error) {
+	if chunks < 1 {
+		chunks = config.Get().Chunks
+	}
+	return ensureSlice(meta, chunks)
+}
+
+// useAttempts bounds how often ensureSlice fetches a slice again that
+// another process evicted before it could be locked for use.
+const useAttempts = 3
+
+// ensureSlice makes sure the slice is in the cache and verified, and
+// returns its path with the blob's shared lock held: eviction skips the
+// blob until the caller releases it.
+func ensureSlice(meta *models.SliceMetadata, chunks int) (string, *utils.FileLock, error) {
+	for attempt := 1; attempt <= useAttempts; attempt++ {
+		var path string
+		var err error
+		if gitsrc.IsSource(meta.URL) {
+			path, err = ensureGitSlice(meta)
+		} else {
+			path, err = storeSlice(meta, chunks)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		hash := filepath.Base(path)
+		lock, err := cache.RLock("sha256-"+hash, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		if cache.HasBlob(hash) {
+			cache.Touch(path)
+			return path, lock, nil
 		}
+		lock.Unlock()
 	}
+	return "", nil, fmt.Errorf("engine: %s keeps being evicted from the cache by another process", meta.ID)
+}
 
-	if !found {
-		return "", fmt.Errorf("engine: slice %s not found in registry", alias)
+// storeSlice downloads the slice into the cache unless it is stored
+// already, verifies it and returns its path.
+func storeSlice(meta *models.SliceMetadata, chunks int) (string, error) {
+	alias := meta.ID
+	version := meta.Version
+	if version == "" {
+		version = "latest"
 	}
 
-	cachePath, _ := cache.GetSlicePath(alias, "latest")
+	blobPath, err := cache.BlobPath(meta.Hash)
+	if err != nil {
+		return "", fmt.Errorf("security alert: %s: %w", alias, err)
+	}
+
+	// Another process may be downloading the same blob: wait for it. A blob
+	// already stored is only read, so a shared lock does, and the same blob
+	// can be used twice (or be in use elsewhere) without deadlocking.
+	waiting := func() {
+		fmt.Printf("Waiting for another swiftstack process to fetch %s...\n", alias)
+	}
+	lock, err := cache.RLock("sha256-"+meta.Hash, waiting)
+	if err != nil {
+		return "", err
+	}
+	if !cache.HasBlob(meta.Hash) {
+		lock.Unlock()
+		if lock, err = cache.Lock("sha256-"+meta.Hash, waiting); err != nil {
+			return "", err
+		}
+	}
+	defer lock.Unlock()
+
+	// Blobs are stored under their hash only once verified, so a cached one
+	// is used without hashing it again
+	if !cache.HasBlob(meta.Hash) {
+		// An interrupted download stays in tmp/ and is resumed next time
+		tmp, err := cache.PartialPath(meta.Hash)
+		if err != nil {
+			return "", err
+		}
 
-	// 2. Download if missing
-	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
 		fmt.Printf("Downloading %s...\n", alias)
-		if err := utils.DownloadFileConcurrent(url, cachePath, 4); err != nil {
+		if err := utils.Download(meta.URL, tmp, chunks); err != nil {
+			return "", err
+		}
+		fmt.Printf("Verifying integrity of %s...\n", alias)
+		if err := utils.VerifyFileHash(tmp, meta.Hash); err != nil {
+			os.Remove(tmp)
+			os.Remove(tmp + utils.PartialSuffix)
+			return "", fmt.Errorf("security alert: %w", err)
+		}
+		if _, err := cache.StoreBlob(tmp, meta.Hash); err != nil {
 			return "", err
 		}
 	}
+	if err := cache.WriteRef(meta.ID, version, cache.Ref{Hash: meta.Hash}); err != nil {
+		return "", fmt.Errorf("engine: failed to record %s: %w", alias, err)
+	}
 
-	// 3. VERIFY INTEGRITY (Senior Level Security)
-	fmt.Printf("Verifying integrity of %s...\n", alias)
-	if err := utils.VerifyFileHash(cachePath, targetHash); err != nil {
-		// If hash fails, delete the corrupted file so it can be re-downloaded
-		os.Remove(cachePath)
-		return "", fmt.Errorf("security alert: %w", err)
+	// VERIFY SIGNATURE according to the registry's trust policy
+	if err := verifySliceSignature(meta, blobPath); err != nil {
+		// The content matches the manifest; only the signature is suspect
+		os.Remove(blobPath + trust.SignatureExt)
+		return "", fmt.Errorf("security alert: %s: %w", alias, err)
 	}
 
-	return cachePath, nil
+	cache.Touch(blobPath)
+	return blobPath, nil
+}
+
+// warnLifecycle reports a deprecated version, or a yanked one that the
+// user pinned explicitly (FindSlice never picks those on its own).
+func warnLifecycle(meta *models.SliceMetadata) {
+	ref := meta.ID + "@" + meta.Version
+	if y := meta.Yanked; y != nil {
+		msg := fmt.Sprintf("Warning: %s has been yanked", ref)
+		if y.Reason != "" {
+			msg += ": " + y.Reason
+		}
+		fmt.Println(msg + " (used because it was pinned)")
+	}
+	if d := meta.Deprecated; d != nil {
+		msg := fmt.Sprintf("Warning: %s is deprecated", ref)
+		if d.Message != "" {
+			msg += ": " + d.Message
+		}
+		if d.Replacement != "" {
+			msg += fmt.Sprintf(" (use %s instead)", d.Replacement)
+		}
+		fmt.Println(msg)
+	}
+}
+
+// ensureGitSlice packs a slice from a git repository into the cache. The ref
+// is resolved on every run; when the manifest pins a commit in the hash
+// field, the ref must still point at it. The packed archive is stored as a
+// blob like any other slice; its cache ref (id@git-<commit>) records the
+// commit, so later runs reuse it without packing again.
+func ensureGitSlice(meta *models.SliceMetadata) (string, error) {
+	src, err := gitsrc.Parse(meta.URL)
+	if err != nil {
+		return "", fmt.Errorf("engine: %w", err)
+	}
+	ctx := context.Background()
+	commit, err := gitsrc.Resolve(ctx, src)
+	if err != nil {
+		return "", fmt.Errorf("engine: %s: %w", meta.ID, err)
+	}
+	if meta.Hash != "" && meta.Hash != commit {
+		return "", fmt.Errorf("security alert: %s: %s resolves to commit %s, but the manifest pins %s",
+			meta.ID, src.Ref, commit, meta.Hash)
+	}
+
+	// Git sources carry no detached signatures; a signed manifest that pins
+	// the commit gives the same guarantee
+	reg, _ := config.Get().Registry(meta.Registry)
+	if reg.Trust.SliceMode() == config.SignaturesRequire && meta.Hash == "" {
+		return "", fmt.Errorf("security alert: %s: registry '%s' requires signed slices; pin the commit in the hash field", meta.ID, meta.Registry)
+	}
+
+	version := "git-" + commit
+	lock, err := cache.Lock("git-"+meta.ID+"@"+commit, func() {
+		fmt.Printf("Waiting for another swiftstack process to pack %s...\n", meta.ID)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	if ref, err := cache.ReadRef(meta.ID, version); err == nil && ref.Commit == commit && cache.HasBlob(ref.Hash) {
+		path, _ := cache.BlobPath(ref.Hash)
+		cache.Touch(path)
+		return path, nil
+	}
+
+	tmp, err := cache.TempPath()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	fmt.Printf("Packing %s from %s at %s...\n", meta.ID, src, commit[:12])
+	if err := gitsrc.Pack(ctx, src, commit, tmp); err != nil {
+		return "", fmt.Errorf("engine: %s: %w", meta.ID, err)
+	}
+	hash, err := utils.HashFile(tmp)
+	if err != nil {
+		return "", err
+	}
+	path, err := cache.StoreBlob(tmp, hash)
+	if err != nil {
+		return "", err
+	}
+	ref := cache.Ref{Hash: hash, Source: src.String(), Commit: commit}
+	if err := cache.WriteRef(meta.ID, version, ref); err != nil {
+		return "", fmt.Errorf("engine: failed to record %s: %w", meta.ID, err)
+	}
+	return path, nil
+}
+
+// verifySliceSignature checks the detached signature of a cached slice
+// (fetched from the slice URL + ".sig" on first use) against the trust
+// policy of the registry that listed it.
+func verifySliceSignature(meta *models.SliceMetadata, cachePath string) error {
+	reg, _ := config.Get().Registry(meta.Registry)
+	mode := reg.Trust.SliceMode()
+	if mode == config.SignaturesOff {
+		return nil
+	}
+
+	sigPath := cachePath + trust.SignatureExt
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		sig, err = utils.ReadResource(meta.URL + trust.SignatureExt)
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return err
+		}
+		if len(sig) > 0 {
+			if err := os.WriteFile(sigPath, sig, 0644); err != nil {
+				return fmt.Errorf("engine: failed to cache signature: %w", err)
+			}
+		}
+	}
+
+	signer, err := trust.VerifySlice(cachePath, meta.Hash, sig, reg.Trust.Signers)
+	if err != nil {
+		if mode == config.SignaturesWarn && errors.Is(err, trust.ErrSliceUnsigned) {
+			fmt.Printf("Warning: %s is unsigned.\n", meta.ID)
+			return nil
+		}
+		if mode == config.SignaturesWarn {
+			fmt.Printf("Warning: signature check for %s failed: %v\n", meta.ID, err)
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("Signature of %s verified (signed by '%s').\n", meta.ID, signer)
+	return nil
+}
+
+// placeSlice writes the files of the cached slice at slicePath into dest:
+// by extracting the archive, or, in the other materialize modes, by cloning
+// the slice's extracted tree from the cache.
+func placeSlice(slicePath, dest, mode string) error {
+	switch mode {
+	case "", "extract":
+		return extractSlice(slicePath, dest)
+	case "auto", "reflink", "copy":
+	default:
+		return fmt.Errorf("engine: unsupported materialize mode %q (want one of %s)",
+			mode, strings.Join(config.MaterializeModes, ", "))
+	}
+
+	// Cached slices are blobs named by their hash
+	tree, lock, err := ensureTree(filepath.Base(slicePath), slicePath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	opts := utils.CloneOptions{Reflink: mode != "copy"}
+	if mode == "auto" {
+		opts.Hardlink = inNodeModules
+	}
+	counts, err := utils.CloneTree(tree, dest, opts)
+	if err != nil {
+		return fmt.Errorf("engine: %w", err)
+	}
+	fmt.Printf("Materialized %d reflinked, %d hardlinked and %d copied files.\n",
+		counts[utils.CloneReflink], counts[utils.CloneHardlink], counts[utils.CloneCopy])
+	return nil
+}
+
+// inNodeModules reports whether a slash-separated path is inside a
+// node_modules directory. Package managers replace those files rather than
+// edit them, so they are safe to hardlink to the tree cache; everything else
+// (package.json in particular) is copied.
+func inNodeModules(rel string) bool {
+	return strings.HasPrefix(rel, "node_modules/") || strings.Contains(rel, "/node_modules/")
+}
+
+// ensureTree returns the extracted tree of the blob with the given hash,
+// extracting it into the cache on first use. The tree's shared lock is held
+// on return, so eviction skips it until the caller releases the lock.
+func ensureTree(hash, blobPath string) (string, *utils.FileLock, error) {
+	path, err := cache.TreePath(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	waiting := func() {
+		fmt.Println("Waiting for another swiftstack process to extract a slice...")
+	}
+	for attempt := 1; attempt <= useAttempts; attempt++ {
+		lock, err := cache.RLock("tree-"+hash, waiting)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := os.Stat(path); err == nil {
+			cache.Touch(path)
+			return path, lock, nil
+		}
+		lock.Unlock()
+		if err := storeTree(hash, path, blobPath, waiting); err != nil {
+			return "", nil, err
+		}
+	}
+	return "", nil, fmt.Errorf("engine: the tree of %s keeps being evicted from the cache by another process", hash)
+}
+
+// storeTree extracts the blob into the tree cache unless another process
+// did so first.
+func storeTree(hash, path, blobPath string, waiting func()) error {
+	lock, err := cache.Lock("tree-"+hash, waiting)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	tmp, err := cache.TempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractSlice(blobPath, tmp); err != nil {
+		return err
+	}
+	// Files hardlinked into projects must not be edited in place
+	if err := utils.ProtectTree(tmp); err != nil {
+		return fmt.Errorf("engine: %w", err)
+	}
+	_, err = cache.StoreTree(tmp, hash)
+	return err
 }
 
 func extractSlice(slicePath, dest string) error {
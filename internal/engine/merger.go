@@ -10,6 +10,7 @@ import (
 	"os"
 
 	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/utils"
 )
 
 // MergePackageJSON reads two package.json files and merges their dependencies,
@@ -88,5 +89,7 @@ func writeJSON(path string, pkg *models.PackageJSON) error {
 	// Ensure we end with a newline to follow standard JSON formatting
 	data = append(data, '\n')
 
-	return os.WriteFile(path, data, 0644)
+	// Replace the file rather than write into it, so a package.json that is
+	// hardlinked to a cached tree is never changed through the link
+	return utils.WriteFileAtomic(path, data, 0644)
 }
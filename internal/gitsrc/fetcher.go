@@ -0,0 +1,56 @@
+/*
+Package gitsrc turns directories in git repositories into slices.
+fetcher.go registers the git+ schemes with the slice downloader, so a
+manifest entry's url can point at a repository. Read returns files from the
+repository at the ref, which is how url + ".sig" finds a committed signature.
+*/
+package gitsrc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+func init() {
+	for _, scheme := range []string{"file", "http", "https", "ssh"} {
+		utils.RegisterFetcher(Prefix+scheme, fetcher{})
+	}
+}
+
+type fetcher struct{}
+
+func (fetcher) Download(u *url.URL, dest string, _ int) error {
+	s, err := Parse(u.String())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	commit, err := Resolve(ctx, s)
+	if err != nil {
+		return err
+	}
+	return Pack(ctx, s, commit, dest)
+}
+
+func (fetcher) Read(u *url.URL) ([]byte, error) {
+	s, err := Parse(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if s.Subdir == "" {
+		return nil, utils.ErrNotFound
+	}
+	ctx := context.Background()
+	commit, err := Resolve(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadFile(ctx, s, commit, s.Subdir)
+	if errors.Is(err, ErrNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	return data, err
+}
@@ -0,0 +1,285 @@
+/*
+Package gitsrc turns directories in git repositories into slices.
+A location such as git+file:///srv/addons.git#v1.2.0:tailwind names a
+repository, a ref (branch, tag or commit; HEAD when omitted) and an optional
+subdirectory. The ref is resolved to a commit and the subdirectory at that
+commit is packed by the builder package, so a slice never depends on the
+state of a working tree. The git command-line tool must be installed.
+*/
+package gitsrc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/004Ongoro/swiftstack/internal/builder"
+	"github.com/004Ongoro/swiftstack/internal/cache"
+)
+
+// Prefix marks git locations: git+file, git+https, git+ssh...
+const Prefix = "git+"
+
+// ErrNotFound is returned when a ref, directory or file does not exist.
+var ErrNotFound = errors.New("gitsrc: not found")
+
+// fetched holds the mirrors already updated by this process.
+var fetched sync.Map
+
+var commitPattern = regexp.MustCompile(`^(?:[0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Source is a parsed git location.
+type Source struct {
+	Repo   string // repository URL without the git+ prefix
+	Ref    string // branch, tag or commit
+	Subdir string // slash-separated directory packed as the slice root; empty for the whole tree
+}
+
+// IsSource reports whether raw is a git location.
+func IsSource(raw string) bool {
+	return strings.HasPrefix(strings.ToLower(raw), Prefix)
+}
+
+// IsCommit reports whether s is a full commit id (SHA-1 or SHA-256 repositories).
+func IsCommit(s string) bool {
+	return commitPattern.MatchString(s)
+}
+
+// Parse parses git+<repo-url>#<ref>:<subdir>. Both parts of the fragment are
+// optional: git+file:///repo is HEAD of the whole repository.
+func Parse(raw string) (Source, error) {
+	if !IsSource(raw) {
+		return Source{}, fmt.Errorf("gitsrc: %q is not a git location (want git+file:///path/repo#ref:subdir)", raw)
+	}
+	repo, fragment, _ := strings.Cut(raw[len(Prefix):], "#")
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme == "" {
+		return Source{}, fmt.Errorf("gitsrc: invalid repository URL in %q", raw)
+	}
+	if u.Scheme == "file" && u.Path == "" {
+		return Source{}, fmt.Errorf("gitsrc: %q has no repository path", raw)
+	}
+
+	// Refs cannot contain ':', so the first one separates ref and directory
+	ref, subdir, _ := strings.Cut(fragment, ":")
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return Source{}, fmt.Errorf("gitsrc: invalid ref %q in %q", ref, raw)
+	}
+	for _, part := range strings.Split(subdir, "/") {
+		if part == ".." {
+			return Source{}, fmt.Errorf("gitsrc: directory in %q must stay inside the repository", raw)
+		}
+	}
+	subdir = strings.Trim(path.Clean("/"+subdir), "/")
+
+	return Source{Repo: repo, Ref: ref, Subdir: subdir}, nil
+}
+
+// String returns the git+ location of the source.
+func (s Source) String() string {
+	out := Prefix + s.Repo + "#" + s.Ref
+	if s.Subdir != "" {
+		out += ":" + s.Subdir
+	}
+	return out
+}
+
+// Name derives a slice id from the subdirectory, or from the repository
+// name when the whole tree is used.
+func (s Source) Name() string {
+	if s.Subdir != "" {
+		return path.Base(s.Subdir)
+	}
+	u, _ := url.Parse(s.Repo)
+	name := path.Base(strings.TrimSuffix(u.Path, "/"))
+	return strings.TrimSuffix(name, ".git")
+}
+
+// Resolve returns the commit the source's ref points at. Remote repositories
+// are mirrored into the cache first, so this fetches.
+func Resolve(ctx context.Context, s Source) (string, error) {
+	dir, release, err := repoDir(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	out, err := git(ctx, dir, "rev-parse", "--verify", "--quiet", s.Ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("%w: ref %q in %s", ErrNotFound, s.Ref, s.Repo)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Pack writes the source's directory at commit to dest as a .tar.zst slice.
+func Pack(ctx context.Context, s Source, commit, dest string) error {
+	dir, release, err := repoDir(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer release()
+	tree := commit
+	if s.Subdir != "" {
+		tree += ":" + s.Subdir
+	}
+	if out, err := git(ctx, dir, "cat-file", "-t", tree); err != nil || strings.TrimSpace(string(out)) != "tree" {
+		return fmt.Errorf("%w: directory %q at %s in %s", ErrNotFound, s.Subdir, short(commit), s.Repo)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "archive", "--format=tar", tree)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("gitsrc: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("gitsrc: failed to run git: %w", err)
+	}
+	packErr := builder.CreateSliceFromTar(stdout, dest)
+	if packErr != nil {
+		cmd.Process.Kill()
+	}
+	if err := cmd.Wait(); err != nil && packErr == nil {
+		packErr = fmt.Errorf("gitsrc: git archive failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if packErr != nil {
+		os.Remove(dest)
+	}
+	return packErr
+}
+
+// ReadFile returns the content of a file in the repository at commit.
+func ReadFile(ctx context.Context, s Source, commit, name string) ([]byte, error) {
+	dir, release, err := repoDir(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	object := commit + ":" + strings.TrimPrefix(path.Clean("/"+name), "/")
+	if _, err := git(ctx, dir, "cat-file", "-e", object); err != nil {
+		return nil, ErrNotFound
+	}
+	return git(ctx, dir, "cat-file", "blob", object)
+}
+
+// mirrorAttempts bounds how often repoDir clones a mirror again that
+// another process evicted before it could be locked for use.
+const mirrorAttempts = 3
+
+// repoDir returns a directory git can read the source from: the repository
+// itself for file URLs, otherwise a bare mirror under <cache>/git that is
+// fetched once per run. A mirror is kept from eviction by a shared lock
+// until the caller calls release.
+func repoDir(ctx context.Context, s Source) (dir string, release func(), err error) {
+	u, err := url.Parse(s.Repo)
+	if err != nil {
+		return "", nil, fmt.Errorf("gitsrc: invalid repository URL %q", s.Repo)
+	}
+	if u.Scheme == "file" {
+		p := u.Path
+		// file:///C:/repo has the path /C:/repo
+		if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+			p = p[1:]
+		}
+		return filepath.FromSlash(p), func() {}, nil
+	}
+
+	cacheDir, err := cache.GetCacheDir()
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256([]byte(s.Repo))
+	dir = filepath.Join(cacheDir, "git", hex.EncodeToString(sum[:8])+".git")
+	key := "git-mirror-" + filepath.Base(dir)
+
+	for attempt := 1; attempt <= mirrorAttempts; attempt++ {
+		if err := updateMirror(ctx, s.Repo, dir, key); err != nil {
+			return "", nil, err
+		}
+		lock, err := cache.RLock(key, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := os.Stat(dir); err == nil {
+			return dir, func() { lock.Unlock() }, nil
+		}
+		lock.Unlock()
+		fetched.Delete(dir)
+	}
+	return "", nil, fmt.Errorf("gitsrc: the mirror of %s keeps being evicted from the cache by another process", s.Repo)
+}
+
+// updateMirror clones repo into the mirror at dir, or fetches into it, once
+// per run.
+func updateMirror(ctx context.Context, repo, dir, key string) error {
+	if _, ok := fetched.Load(dir); ok {
+		return nil
+	}
+	// Concurrent fetches into one mirror fail on git's ref locks
+	lock, err := cache.Lock(key, nil)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if _, err := os.Stat(dir); err == nil {
+		if _, err := git(ctx, dir, "fetch", "--quiet", "--prune", "--tags", "origin"); err != nil {
+			return err
+		}
+		cache.Touch(dir)
+		fetched.Store(dir, true)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("gitsrc: %w", err)
+	}
+	if _, err := git(ctx, "", "clone", "--quiet", "--mirror", "--", repo, dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	// The clone keeps the remote's timestamps; count it as used now for LRU eviction
+	cache.Touch(dir)
+	fetched.Store(dir, true)
+	return nil
+}
+
+// git runs a git command in dir and returns its standard output.
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	name := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// Never prompt for credentials; fail instead
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("gitsrc: git not found in PATH: please install it to use git slice sources")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gitsrc: git %s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func short(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,141 @@
+package gitsrc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/archiver"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Source
+		name string
+		ok   bool
+	}{
+		{"git+file:///srv/addons.git#v1.2.0:tailwind", Source{"file:///srv/addons.git", "v1.2.0", "tailwind"}, "tailwind", true},
+		{"git+file:///srv/next-base", Source{"file:///srv/next-base", "HEAD", ""}, "next-base", true},
+		{"git+https://git.example.com/ui.git#main:/kits/auth/", Source{"https://git.example.com/ui.git", "main", "kits/auth"}, "auth", true},
+		{"git+file:///srv/addons.git#main:../etc", Source{}, "", false},
+		{"git+file:///srv/addons.git#--upload-pack=x", Source{}, "", false},
+		{"git+file://", Source{}, "", false},
+		{"https://example.com/a.tar.zst", Source{}, "", false},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.raw)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v, ok=%v", tt.raw, got, err, tt.want, tt.ok)
+			continue
+		}
+		if tt.ok && got.Name() != tt.name {
+			t.Errorf("Parse(%q).Name() = %q, want %q", tt.raw, got.Name(), tt.name)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(repo, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "--quiet")
+	write("tailwind/tailwind.config.js", "v1")
+	write("tailwind.sig", "signature")
+	write("other/README.md", "not packed")
+	run("add", ".")
+	run("commit", "--quiet", "-m", "v1")
+	run("tag", "v1")
+	write("tailwind/tailwind.config.js", "v2 (uncommitted)")
+
+	src, err := Parse("git+file://" + filepath.ToSlash(repo) + "#v1:tailwind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	commit, err := Resolve(ctx, src)
+	if err != nil || !IsCommit(commit) {
+		t.Fatalf("Resolve = %q, %v", commit, err)
+	}
+
+	// The tag is packed, not the working tree, and packing is reproducible
+	dest := filepath.Join(t.TempDir(), "tailwind.tar.zst")
+	if err := Pack(ctx, src, commit, dest); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := utils.HashFile(dest)
+	if err := Pack(ctx, src, commit, dest); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := utils.HashFile(dest); second != first {
+		t.Errorf("packing the same commit twice gave %s and %s", first, second)
+	}
+
+	f, _ := os.Open(dest)
+	defer f.Close()
+	out := t.TempDir()
+	if err := archiver.Extract(f, out); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "tailwind.config.js")); !bytes.Equal(got, []byte("v1")) {
+		t.Errorf("packed file = %q, want the tagged content", got)
+	}
+	if _, err := os.Stat(filepath.Join(out, "README.md")); err == nil {
+		t.Error("files outside the subdirectory were packed")
+	}
+
+	// Signatures next to the directory are read at the ref
+	sig, err := utils.ReadResource(src.String() + ".sig")
+	if err != nil || string(sig) != "signature" {
+		t.Errorf("ReadResource(.sig) = %q, %v", sig, err)
+	}
+
+	missing := Source{Repo: src.Repo, Ref: "v1", Subdir: "nope"}
+	if err := Pack(ctx, missing, commit, dest); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Pack of a missing directory: %v, want ErrNotFound", err)
+	}
+	if _, err := Resolve(ctx, Source{Repo: src.Repo, Ref: "v9"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve of a missing tag: %v, want ErrNotFound", err)
+	}
+
+	// Symlinks would be dropped on extraction, so packing refuses them
+	write("linked/target.js", "x")
+	if err := os.Symlink("target.js", filepath.Join(repo, "linked", "link.js")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	run("add", ".")
+	run("commit", "--quiet", "-m", "v2")
+	linked := Source{Repo: src.Repo, Ref: "HEAD", Subdir: "linked"}
+	head, err := Resolve(ctx, linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Pack(ctx, linked, head, dest); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("Pack of a symlink: %v, want an error naming it", err)
+	}
+}
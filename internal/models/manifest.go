@@ -11,6 +11,40 @@ type SliceMetadata struct {
 	URL         string `json:"url"`
 	Version     string `json:"version"`
 	Hash        string `json:"hash"` // SHA-256 hash for integrity verification
+	Size        int64  `json:"size,omitempty"` // Size of the .tar.zst in bytes
+
+	// Dependencies lists the ids of other slices this slice needs.
+	Dependencies []string `json:"dependencies,omitempty"`
+
+	// Category groups addons in the wizard (e.g. "auth", "styling").
+	Category string   `json:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// CompatibleBases restricts an addon to the listed base ids, each with a
+	// version constraint such as "^1.2.0" or ">=14.0.0 <16.0.0" ("" or "*"
+	// for any version). Addons without it work with every base.
+	CompatibleBases map[string]string `json:"compatibleBases,omitempty"`
+
+	// Deprecated marks a version that still works but should not be used
+	// for new projects. create warns about it.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// Yanked marks a withdrawn version, e.g. because of a security issue.
+	// create only uses it when pinned as id@version.
+	Yanked *Yank `json:"yanked,omitempty"`
+
+	// Registry is the name of the configured registry the entry was loaded from.
+	// It is filled in by cache.LoadManifest and never serialized.
+	Registry string `json:"-"`
+}
+
+// Deprecation explains why a version is deprecated and what to use instead.
+type Deprecation struct {
+	Message     string `json:"message,omitempty"`
+	Replacement string `json:"replacement,omitempty"` // an id or id@version
+}
+
+// Yank records why a version was withdrawn.
+type Yank struct {
+	Reason string `json:"reason,omitempty"`
 }
 
 // RemoteManifest is the structure of the master list hosted online.
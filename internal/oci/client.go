@@ -0,0 +1,478 @@
+/*
+Package oci stores and retrieves slices as OCI artifacts.
+client.go implements the parts of the distribution API SwiftStack needs:
+pushing a single-layer artifact and pulling its layer, with anonymous or
+credentialed token auth.
+*/
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// Media types of SwiftStack artifacts.
+const (
+	ArtifactTypeSlice     = "application/vnd.swiftstack.slice.v1"
+	MediaTypeSliceLayer   = "application/vnd.swiftstack.slice.layer.v1.tar+zstd"
+	MediaTypeSignature    = "application/vnd.swiftstack.signature.v1"
+	MediaTypeManifestJSON = "application/vnd.swiftstack.manifest.v1+json"
+
+	mediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeEmptyConfig   = "application/vnd.oci.empty.v1+json"
+)
+
+// emptyConfig is the OCI "empty" config blob used by artifacts.
+var emptyConfig = []byte("{}")
+
+// ErrNotFound is returned when the repository, tag or blob does not exist.
+var ErrNotFound = errors.New("oci: not found")
+
+// Descriptor identifies a blob or manifest by content.
+type Descriptor struct {
+	MediaType    string `json:"mediaType"`
+	ArtifactType string `json:"artifactType,omitempty"`
+	Digest       string `json:"digest"`
+	Size         int64  `json:"size"`
+}
+
+// Hash returns the hex SHA-256 of the descriptor, the format registry
+// manifests use for slice hashes.
+func (d Descriptor) Hash() string {
+	return strings.TrimPrefix(d.Digest, "sha256:")
+}
+
+// manifest is an OCI image manifest carrying an artifact.
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	ArtifactType  string       `json:"artifactType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Client talks to OCI registries.
+type Client struct {
+	HTTP *http.Client
+	// Username and Password are sent to the token service (or as basic auth)
+	// when a registry asks for credentials. When empty, the per-host
+	// credentials of utils.Credentials are used, if any.
+	Username, Password string
+	// Insecure lists hosts reached over plain HTTP. localhost and loopback
+	// addresses always are.
+	Insecure []string
+
+	mu     sync.Mutex
+	tokens map[string]string // host + scope -> bearer token
+	basic  map[string]bool   // hosts that asked for basic auth
+}
+
+// NewClient returns a client configured from SWIFTSTACK_OCI_USERNAME,
+// SWIFTSTACK_OCI_PASSWORD and SWIFTSTACK_OCI_INSECURE (comma-separated hosts).
+func NewClient() *Client {
+	c := &Client{
+		HTTP:     utils.HTTPClient,
+		Username: os.Getenv("SWIFTSTACK_OCI_USERNAME"),
+		Password: os.Getenv("SWIFTSTACK_OCI_PASSWORD"),
+	}
+	for _, h := range strings.Split(os.Getenv("SWIFTSTACK_OCI_INSECURE"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			c.Insecure = append(c.Insecure, h)
+		}
+	}
+	return c
+}
+
+// Pull downloads the artifact's single layer to dest and checks it against
+// the layer digest. It returns the layer descriptor.
+func (c *Client) Pull(ctx context.Context, ref Reference, dest string) (Descriptor, error) {
+	layer, err := c.Resolve(ctx, ref)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	resp, err := c.do(ctx, ref, "pull", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint(ref, "blobs/"+layer.Digest), nil)
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return Descriptor{}, fmt.Errorf("oci: fetching layer of %s: %w", ref, err)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && "sha256:"+hex.EncodeToString(h.Sum(nil)) != layer.Digest {
+		err = fmt.Errorf("oci: layer of %s does not match its digest %s", ref, layer.Digest)
+	}
+	if err != nil {
+		os.Remove(dest)
+		return Descriptor{}, err
+	}
+	return layer, nil
+}
+
+// PullBytes returns the artifact's single layer in memory (for signatures
+// and manifests).
+func (c *Client) PullBytes(ctx context.Context, ref Reference) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "swiftstack-oci-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if _, err := c.Pull(ctx, ref, tmp.Name()); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+// Resolve fetches the artifact manifest and returns its layer descriptor.
+func (c *Client) Resolve(ctx context.Context, ref Reference) (Descriptor, error) {
+	resp, err := c.do(ctx, ref, "pull", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint(ref, "manifests/"+ref.manifestRef()), nil)
+		if err == nil {
+			req.Header.Set("Accept", mediaTypeImageManifest)
+		}
+		return req, err
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return Descriptor{}, fmt.Errorf("oci: resolving %s: %w", ref, err)
+	}
+
+	var m manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&m); err != nil {
+		return Descriptor{}, fmt.Errorf("oci: invalid manifest for %s: %w", ref, err)
+	}
+	if len(m.Layers) != 1 {
+		return Descriptor{}, fmt.Errorf("oci: %s has %d layers; slices have exactly one", ref, len(m.Layers))
+	}
+	return m.Layers[0], nil
+}
+
+// Push uploads body as the single layer of an artifact tagged ref.Tag and
+// returns the layer descriptor. Blobs the registry already has are skipped.
+func (c *Client) Push(ctx context.Context, ref Reference, artifactType, mediaType string, body io.ReadSeeker) (Descriptor, error) {
+	if ref.Tag == "" || ref.Digest != "" {
+		return Descriptor{}, fmt.Errorf("oci: push needs a tag, got %s", ref)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("oci: %w", err)
+	}
+	layer := Descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), Size: size}
+
+	configSum := sha256.Sum256(emptyConfig)
+	config := Descriptor{MediaType: mediaTypeEmptyConfig, Digest: "sha256:" + hex.EncodeToString(configSum[:]), Size: int64(len(emptyConfig))}
+
+	if err := c.pushBlob(ctx, ref, config, bytes.NewReader(emptyConfig)); err != nil {
+		return Descriptor{}, err
+	}
+	if err := c.pushBlob(ctx, ref, layer, body); err != nil {
+		return Descriptor{}, err
+	}
+
+	data, err := json.Marshal(manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeImageManifest,
+		ArtifactType:  artifactType,
+		Config:        config,
+		Layers:        []Descriptor{layer},
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	resp, err := c.do(ctx, ref, "pull,push", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.endpoint(ref, "manifests/"+ref.Tag), bytes.NewReader(data))
+		if err == nil {
+			req.Header.Set("Content-Type", mediaTypeImageManifest)
+		}
+		return req, err
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return Descriptor{}, fmt.Errorf("oci: pushing manifest %s: %w", ref, err)
+	}
+	return layer, nil
+}
+
+// pushBlob uploads a blob with a monolithic POST + PUT unless it exists.
+func (c *Client) pushBlob(ctx context.Context, ref Reference, desc Descriptor, body io.ReadSeeker) error {
+	resp, err := c.do(ctx, ref, "pull,push", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodHead, c.endpoint(ref, "blobs/"+desc.Digest), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = c.do(ctx, ref, "pull,push", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(ref, "blobs/uploads/"), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if err := checkStatus(resp, http.StatusAccepted); err != nil {
+		return fmt.Errorf("oci: starting upload to %s: %w", ref.Repository, err)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("oci: registry returned no upload location")
+	}
+	q := location.Query()
+	q.Set("digest", desc.Digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.do(ctx, ref, "pull,push", func() (*http.Request, error) {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, location.String(), io.NopCloser(body))
+		if err == nil {
+			req.ContentLength = desc.Size
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return fmt.Errorf("oci: uploading %s: %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// endpoint builds https://host/v2/<repository>/<path>.
+func (c *Client) endpoint(ref Reference, path string) string {
+	scheme := "https"
+	if c.insecure(ref.Host) {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.Host, ref.Repository, path)
+}
+
+func (c *Client) insecure(host string) bool {
+	name := host
+	if h, _, ok := strings.Cut(strings.TrimPrefix(host, "["), "]"); ok {
+		name = h // [::1]:5000
+	} else if h, _, ok := strings.Cut(host, ":"); ok {
+		name = h
+	}
+	if name == "localhost" || name == "::1" || strings.HasPrefix(name, "127.") {
+		return true
+	}
+	for _, h := range c.Insecure {
+		if h == host || h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// do sends the request built by newReq, answering an auth challenge once.
+// newReq is called again for the retry, so bodies must be rewindable.
+func (c *Client) do(ctx context.Context, ref Reference, actions string, newReq func() (*http.Request, error)) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:%s", ref.Repository, actions)
+	key := ref.Host + " " + scope
+
+	send := func() (*http.Response, error) {
+		req, err := newReq()
+		if err != nil {
+			return nil, fmt.Errorf("oci: %w", err)
+		}
+		c.mu.Lock()
+		token, basic := c.tokens[key], c.basic[ref.Host]
+		c.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if basic {
+			user, pass := c.login(ref.Host)
+			req.SetBasicAuth(user, pass)
+		}
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("oci: %s %s: %w", req.Method, ref.Host, err)
+		}
+		return resp, nil
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	if err := c.authorize(ctx, ref.Host, key, scope, challenge); err != nil {
+		return nil, err
+	}
+	return send()
+}
+
+// login returns the username and password for host.
+func (c *Client) login(host string) (string, string) {
+	if c.Username != "" {
+		return c.Username, c.Password
+	}
+	a := utils.Credentials(host)
+	return a.Username, a.Password
+}
+
+// authorize answers a WWW-Authenticate challenge: Basic is remembered for
+// the host; Bearer fetches a token from the realm for the scope.
+func (c *Client) authorize(ctx context.Context, host, key, scope, challenge string) error {
+	kind, params := parseChallenge(challenge)
+	switch kind {
+	case "basic":
+		if user, _ := c.login(host); user == "" {
+			return fmt.Errorf("oci: %s requires credentials (set SWIFTSTACK_OCI_USERNAME and SWIFTSTACK_OCI_PASSWORD, or configure auth for the host)", host)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.basic == nil {
+			c.basic = make(map[string]bool)
+		}
+		c.basic[host] = true
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("oci: %s: unauthorized", host)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("oci: %s sent an invalid auth challenge", host)
+	}
+	q := realm.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	if s := params["scope"]; s != "" {
+		scope = s
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("oci: %w", err)
+	}
+	if user, pass := c.login(host); user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("oci: token request to %s failed: %w", realm.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oci: token service for %s returned %d", host, resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("oci: invalid token response from %s: %w", realm.Host, err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("oci: token service for %s returned no token", host)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = make(map[string]string)
+	}
+	c.tokens[key] = token
+	return nil
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into its scheme
+// (lowercased) and parameters.
+func parseChallenge(h string) (string, map[string]string) {
+	kind, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var name, value string
+		name, rest, _ = strings.Cut(rest, "=")
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), ",")))
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[name] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return strings.ToLower(kind), params
+}
+
+// checkStatus turns unexpected responses into errors, including the first
+// registry error code when present.
+func checkStatus(resp *http.Response, want int) error {
+	if resp.StatusCode == want {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil && len(body.Errors) > 0 {
+		return fmt.Errorf("registry returned %d (%s: %s)", resp.StatusCode, body.Errors[0].Code, body.Errors[0].Message)
+	}
+	return fmt.Errorf("registry returned %d", resp.StatusCode)
+}
@@ -0,0 +1,112 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/oci/ocitest"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Reference
+		ok   bool
+	}{
+		{"oci://registry.local/slices/next-base:1.0", Reference{Host: "registry.local", Repository: "slices/next-base", Tag: "1.0"}, true},
+		{"localhost:5000/next-base", Reference{Host: "localhost:5000", Repository: "next-base", Tag: "latest"}, true},
+		{"oci://r.example/a@sha256:" + string(bytes.Repeat([]byte("a"), 64)),
+			Reference{Host: "r.example", Repository: "a", Digest: "sha256:" + string(bytes.Repeat([]byte("a"), 64))}, true},
+		{"oci://r.example/Upper:1.0", Reference{}, false},
+		{"oci://r.example", Reference{}, false},
+		{"oci://r.example/a:bad+tag", Reference{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.raw)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, %v; want %+v, ok=%v", tt.raw, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	reg := ocitest.NewRegistry()
+	defer reg.Close()
+	reg.Token, reg.Username, reg.Password = "t0ken", "ci", "secret"
+
+	c := &Client{HTTP: reg.Client(), Username: "ci", Password: "secret"}
+	ref, _ := ParseReference("oci://" + reg.Host() + "/slices/next-base:" + Tag("1.0.0+build.7"))
+
+	slice := []byte("pretend this is a .tar.zst")
+	layer, err := c.Push(context.Background(), ref, ArtifactTypeSlice, MediaTypeSliceLayer, bytes.NewReader(slice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := utils.HashFile(writeTemp(t, slice))
+	if layer.Hash() != want {
+		t.Errorf("layer digest %s, want the slice hash %s", layer.Hash(), want)
+	}
+
+	// Pushing again reuses the blobs
+	before := reg.BlobCount()
+	if _, err := c.Push(context.Background(), ref, ArtifactTypeSlice, MediaTypeSliceLayer, bytes.NewReader(slice)); err != nil {
+		t.Fatal(err)
+	}
+	if reg.BlobCount() != before {
+		t.Errorf("blobs were uploaded twice")
+	}
+
+	dest := filepath.Join(t.TempDir(), "out.tar.zst")
+	if _, err := c.Pull(context.Background(), ref, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, slice) {
+		t.Errorf("pulled %q", got)
+	}
+
+	missing := ref
+	missing.Tag = "2.0.0"
+	if _, err := c.Pull(context.Background(), missing, dest); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing tag: got %v, want ErrNotFound", err)
+	}
+
+	wrong := &Client{HTTP: reg.Client(), Username: "ci", Password: "nope"}
+	if _, err := wrong.Pull(context.Background(), ref, dest); err == nil {
+		t.Error("expected bad credentials to be rejected")
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	reg := ocitest.NewRegistry()
+	defer reg.Close()
+
+	ref, _ := ParseReference("oci://" + reg.Host() + "/slices/tailwind:2.1.0")
+	if _, err := NewClient().Push(context.Background(), ref, ArtifactTypeSlice, MediaTypeSliceLayer, bytes.NewReader([]byte("tw"))); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "tailwind.tar.zst")
+	if err := utils.Download(ref.String(), dest, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "tw" {
+		t.Errorf("downloaded %q", got)
+	}
+	if _, err := utils.ReadResource(ref.String() + ".sig"); !errors.Is(err, utils.ErrNotFound) {
+		t.Errorf("unsigned slice: got %v, want utils.ErrNotFound", err)
+	}
+}
+
+func writeTemp(t *testing.T, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
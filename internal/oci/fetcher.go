@@ -0,0 +1,42 @@
+/*
+Package oci stores and retrieves slices as OCI artifacts.
+fetcher.go registers the oci:// scheme with the slice downloader, so manifest
+entries can point at artifacts. A slice's detached signature is the artifact
+tagged <tag>.sig, which is what appending ".sig" to the URL yields.
+*/
+package oci
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+func init() {
+	utils.RegisterFetcher(Scheme, fetcher{})
+}
+
+type fetcher struct{}
+
+func (fetcher) Download(u *url.URL, dest string, _ int) error {
+	ref, err := ParseReference(u.String())
+	if err != nil {
+		return err
+	}
+	_, err = NewClient().Pull(context.Background(), ref, dest)
+	return err
+}
+
+func (fetcher) Read(u *url.URL) ([]byte, error) {
+	ref, err := ParseReference(u.String())
+	if err != nil {
+		return nil, err
+	}
+	data, err := NewClient().PullBytes(context.Background(), ref)
+	if errors.Is(err, ErrNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	return data, err
+}
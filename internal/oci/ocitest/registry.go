@@ -0,0 +1,173 @@
+/*
+Package ocitest provides an in-memory stand-in for a registry:2 server,
+implementing enough of the OCI distribution API to push and pull artifacts
+in tests.
+*/
+package ocitest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Registry is a fake OCI registry.
+type Registry struct {
+	*httptest.Server
+
+	// Token, when set, makes the registry require a bearer token obtained
+	// from its /token endpoint with Username and Password as basic auth.
+	Token              string
+	Username, Password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte // digest -> content
+	manifests map[string][]byte // repository + "@" + tag or digest -> manifest
+	uploads   map[string]string // upload id -> repository
+	nextID    int
+}
+
+// NewRegistry starts a fake registry. Close it when done.
+func NewRegistry() *Registry {
+	r := &Registry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+		uploads:   make(map[string]string),
+	}
+	r.Server = httptest.NewServer(r)
+	return r
+}
+
+// Host returns host:port, for oci:// references.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// BlobCount returns how many blobs were stored.
+func (r *Registry) BlobCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.blobs)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		user, pass, _ := req.BasicAuth()
+		if r.Username != "" && (user != r.Username || pass != r.Password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, r.Token)
+		return
+	}
+	if r.Token != "" && req.Header.Get("Authorization") != "Bearer "+r.Token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="ocitest"`, r.URL))
+		registryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		repo, id, _ := strings.Cut(path, "/blobs/uploads/")
+		r.serveUpload(w, req, repo, id)
+	case strings.Contains(path, "/blobs/"):
+		_, digest, _ := strings.Cut(path, "/blobs/")
+		r.serveBlob(w, req, digest)
+	case strings.Contains(path, "/manifests/"):
+		repo, ref, _ := strings.Cut(path, "/manifests/")
+		r.serveManifest(w, req, repo, ref)
+	default:
+		registryError(w, http.StatusNotFound, "NAME_UNKNOWN", "unknown path")
+	}
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodPost:
+		r.nextID++
+		id := fmt.Sprintf("upload-%d", r.nextID)
+		r.uploads[id] = repo
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		if r.uploads[id] != repo {
+			registryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "unknown upload")
+			return
+		}
+		data, _ := io.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if digest != digestOf(data) {
+			registryError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest does not match content")
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[digest] = data
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, digest string) {
+	r.mu.Lock()
+	data, ok := r.blobs[digest]
+	r.mu.Unlock()
+	if !ok {
+		registryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	if req.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		digest := digestOf(data)
+		r.manifests[repo+"@"+ref] = data
+		r.manifests[repo+"@"+digest] = data
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		data, ok := r.manifests[repo+"@"+ref]
+		if !ok {
+			registryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", digestOf(data))
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func registryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, message)
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
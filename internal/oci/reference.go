@@ -0,0 +1,90 @@
+/*
+Package oci stores and retrieves slices as OCI artifacts in any registry that
+speaks the OCI distribution API (registry:2, Harbor, GHCR, ECR...).
+reference.go parses oci://host/repository:tag and oci://host/repository@digest.
+*/
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Scheme is the URL scheme of OCI slice locations.
+const Scheme = "oci"
+
+var (
+	repoPattern   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference points at an artifact: a tag or digest in a repository.
+type Reference struct {
+	Host       string // registry host, with optional port
+	Repository string // e.g. slices/next-base
+	Tag        string
+	Digest     string // sha256:..., takes precedence over Tag
+}
+
+// ParseReference parses oci://host/repository[:tag][@digest]. The scheme
+// prefix is optional. A reference without tag or digest means "latest".
+func ParseReference(raw string) (Reference, error) {
+	s := strings.TrimPrefix(raw, Scheme+"://")
+	host, rest, ok := strings.Cut(s, "/")
+	if !ok || host == "" || rest == "" {
+		return Reference{}, fmt.Errorf("oci: invalid reference %q (want oci://host/repository:tag)", raw)
+	}
+
+	var ref Reference
+	ref.Host = host
+	if repo, digest, ok := strings.Cut(rest, "@"); ok {
+		rest, ref.Digest = repo, digest
+		if !digestPattern.MatchString(digest) {
+			return Reference{}, fmt.Errorf("oci: invalid digest in %q", raw)
+		}
+	}
+	// The tag follows the last colon after the last slash (ports live in host)
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+		if !tagPattern.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("oci: invalid tag in %q", raw)
+		}
+	}
+	if !repoPattern.MatchString(rest) {
+		return Reference{}, fmt.Errorf("oci: invalid repository in %q (lowercase letters, digits and separators only)", raw)
+	}
+	ref.Repository = rest
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// String returns the oci:// URL of the reference.
+func (r Reference) String() string {
+	s := Scheme + "://" + r.Host + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// manifestRef is what goes into /v2/<name>/manifests/<reference>.
+func (r Reference) manifestRef() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// Tag converts a slice version into a valid tag: '+' (semver build
+// metadata) is not allowed in tags and becomes '_', as Helm does.
+func Tag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
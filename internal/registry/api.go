@@ -0,0 +1,344 @@
+/*
+Package registry provides tooling for registry maintainers.
+api.go implements the registry server's authenticated publish endpoint:
+
+	PUT /api/v1/slices/{id}/{version}?kind=addon&title=...&description=...&deps=a,b
+	Authorization: Bearer <token>
+	X-Slice-Hash: <sha256 hex>          (required)
+	X-Slice-Signature: <detached sig>   (optional, verified against the keyring)
+
+The body is the .tar.zst slice. Each token may publish only ids matching its
+namespace patterns (path.Match globs such as "acme-*").
+*/
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+	"github.com/blang/semver/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// APIPrefix is the path under which the publish API is mounted.
+const APIPrefix = "/api/v1/slices/"
+
+// DefaultMaxUploadSize caps a single slice upload.
+const DefaultMaxUploadSize = 2 << 30 // 2 GiB
+
+// Headers used by the publish API.
+const (
+	HeaderSliceHash      = "X-Slice-Hash"
+	HeaderSliceSignature = "X-Slice-Signature"
+)
+
+// validID restricts ids to characters that are safe in file names and URLs.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Token grants publish access to a set of id namespaces.
+type Token struct {
+	Name string `yaml:"name"`
+	// Token is the plaintext bearer token. Prefer SHA256 so the file holds no secrets.
+	Token string `yaml:"token,omitempty"`
+	// SHA256 is the hex SHA-256 of the bearer token.
+	SHA256 string `yaml:"sha256,omitempty"`
+	// Namespaces are glob patterns of ids this token may publish ("*" for all).
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// LoadTokens reads a YAML file with a top-level "tokens" list.
+func LoadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("registry: failed to read tokens: %w", err)
+	}
+
+	var file struct {
+		Tokens []Token `yaml:"tokens"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("registry: failed to parse %s: %w", path, err)
+	}
+	for _, t := range file.Tokens {
+		if t.Token == "" && t.SHA256 == "" {
+			return nil, fmt.Errorf("registry: token '%s' has neither token nor sha256", t.Name)
+		}
+		if len(t.Namespaces) == 0 {
+			return nil, fmt.Errorf("registry: token '%s' has no namespaces", t.Name)
+		}
+	}
+	return file.Tokens, nil
+}
+
+// digest returns the SHA-256 the presented bearer token must match.
+func (t Token) digest() []byte {
+	if t.SHA256 != "" {
+		d, _ := hex.DecodeString(strings.ToLower(t.SHA256))
+		return d
+	}
+	d := sha256.Sum256([]byte(t.Token))
+	return d[:]
+}
+
+// allows reports whether the token may publish the given id.
+func (t Token) allows(id string) bool {
+	for _, pattern := range t.Namespaces {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate finds the token presented in the Authorization header.
+func (s *Server) authenticate(r *http.Request) (*Token, bool) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer == "" {
+		return nil, false
+	}
+	presented := sha256.Sum256([]byte(bearer))
+
+	// Compare against every token so timing doesn't reveal which one matched
+	var match *Token
+	for i := range s.opts.Tokens {
+		if subtle.ConstantTimeCompare(presented[:], s.opts.Tokens[i].digest()) == 1 {
+			match = &s.opts.Tokens[i]
+		}
+	}
+	return match, match != nil
+}
+
+// apiError is the JSON body of a failed API call.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, apiError{Error: fmt.Sprintf(format, args...)})
+}
+
+// servePublish handles PUT /api/v1/slices/{id}/{version}.
+func (s *Server) servePublish(w http.ResponseWriter, r *http.Request) {
+	if len(s.opts.Tokens) == 0 {
+		writeAPIError(w, http.StatusNotFound, "publishing is disabled on this registry")
+		return
+	}
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", "PUT")
+		writeAPIError(w, http.StatusMethodNotAllowed, "use PUT")
+		return
+	}
+
+	id, version, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	if !ok || !validID.MatchString(id) || strings.Contains(version, "/") {
+		writeAPIError(w, http.StatusNotFound, "expected %s{id}/{version}", APIPrefix)
+		return
+	}
+	if _, err := semver.Parse(version); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "version '%s' is not valid semver", version)
+		return
+	}
+
+	token, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="swiftstack"`)
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	if !token.allows(id) {
+		writeAPIError(w, http.StatusForbidden, "token '%s' may not publish '%s'", token.Name, id)
+		return
+	}
+
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	if kind == "" {
+		kind = "addon"
+	}
+	if kind != "base" && kind != "addon" {
+		writeAPIError(w, http.StatusBadRequest, "kind must be 'base' or 'addon'")
+		return
+	}
+
+	wantHash := strings.ToLower(r.Header.Get(HeaderSliceHash))
+	if err := checkHash(wantHash); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s header: %v", HeaderSliceHash, err)
+		return
+	}
+
+	entry := models.SliceMetadata{
+		ID:          id,
+		Version:     version,
+		Title:       q.Get("title"),
+		Description: q.Get("description"),
+	}
+	if deps := q.Get("deps"); deps != "" {
+		entry.Dependencies = strings.Split(deps, ",")
+	}
+
+	// 1. Stream the body into a hidden temp file, hashing as we go
+	tmp, size, hash, err := s.receive(r)
+	if tmp != "" {
+		defer os.Remove(tmp)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "slice exceeds %d bytes", tooLarge.Limit)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "upload failed: %v", err)
+		return
+	}
+	if hash != wantHash {
+		writeAPIError(w, http.StatusBadRequest, "hash mismatch: header says %s, body is %s", wantHash, hash)
+		return
+	}
+	entry.Hash, entry.Size = hash, size
+
+	// 2. Optional signature, checked against the server's keyring
+	var sig []byte
+	if v := r.Header.Get(HeaderSliceSignature); v != "" {
+		sig = []byte(v + "\n")
+		if _, err := trust.VerifySlice(tmp, hash, sig, nil); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "signature rejected: %v", err)
+			return
+		}
+	}
+
+	// 3. Move it into place and record it, invisible to readers until complete
+	status, err := s.commit(r.Context(), tmp, sig, &entry, kind)
+	if err != nil {
+		writeAPIError(w, status, "%v", err)
+		return
+	}
+
+	fmt.Printf("Published %s@%s (%d bytes) with token '%s'\n", id, version, size, token.Name)
+	entry.URL = joinURL(s.baseURL(r), escapePath(sliceRelPath(id, version, kind)))
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+// receive writes the request body to a temp file inside the registry
+// directory (so the final rename is atomic) and returns its size and hash.
+func (s *Server) receive(r *http.Request) (string, int64, string, error) {
+	limit := s.opts.MaxUploadSize
+	if limit <= 0 {
+		limit = DefaultMaxUploadSize
+	}
+
+	f, err := os.CreateTemp(s.opts.Dir, ".upload-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	body := http.MaxBytesReader(nil, r.Body, limit)
+	size, err := io.Copy(io.MultiWriter(f, h), body)
+	if err != nil {
+		return f.Name(), 0, "", err
+	}
+	if err := f.Close(); err != nil {
+		return f.Name(), 0, "", err
+	}
+	return f.Name(), size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// commit renames an uploaded slice into place and adds it to registry.json
+// while holding the manifest write lock. It returns an HTTP status on failure.
+func (s *Server) commit(ctx context.Context, tmp string, sig []byte, entry *models.SliceMetadata, kind string) (int, error) {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	existing, err := s.scan()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, sl := range existing {
+		if sl.meta.ID == entry.ID && sl.meta.Version == entry.Version {
+			return http.StatusConflict, fmt.Errorf("%s@%s is already published", entry.ID, entry.Version)
+		}
+	}
+
+	rel := sliceRelPath(entry.ID, entry.Version, kind)
+	dest := filepath.Join(s.opts.Dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if sig != nil {
+		if err := utils.WriteFileAtomic(dest+trust.SignatureExt, sig, 0644); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(dest + trust.SignatureExt)
+		return http.StatusInternalServerError, fmt.Errorf("failed to store slice: %v", err)
+	}
+
+	// Keep titles, descriptions and kinds in registry.json, like 'publish --to <dir>'
+	record := *entry
+	record.URL = rel
+	if err := s.record(ctx, record, kind); err != nil {
+		os.Remove(dest)
+		os.Remove(dest + trust.SignatureExt)
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusCreated, nil
+}
+
+// record adds an entry to the directory's registry.json.
+func (s *Server) record(ctx context.Context, entry models.SliceMetadata, kind string) error {
+	b := &localBackend{dir: s.opts.Dir, manifest: "registry.json"}
+	for attempt := 1; ; attempt++ {
+		data, rev, err := b.ReadManifest(ctx)
+		if err != nil {
+			return err
+		}
+		m, err := parseManifest(data)
+		if err != nil {
+			return err
+		}
+		addEntry(m, entry, kind)
+
+		out, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = b.WriteManifest(ctx, append(out, '\n'), rev)
+		if errors.Is(err, ErrConflict) && attempt < maxManifestAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// sliceRelPath is where an uploaded slice is stored inside the registry directory.
+func sliceRelPath(id, version, kind string) string {
+	name := fmt.Sprintf("%s@%s%s", id, version, sliceExt)
+	if kind == "base" {
+		return "bases/" + name
+	}
+	return name
+}
@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+)
+
+func newPublishServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("ci-secret"))
+
+	s, err := NewServer(ServerOptions{Dir: dir, MaxUploadSize: 1024, Tokens: []Token{
+		{Name: "admin", Token: "admin-secret", Namespaces: []string{"*"}},
+		{Name: "ci", SHA256: hex.EncodeToString(sum[:]), Namespaces: []string{"acme-*"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts, dir
+}
+
+func TestPublishAPI(t *testing.T) {
+	ts, dir := newPublishServer(t)
+
+	body := []byte("slice contents")
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	put := func(path, token, hash string, body []byte) int {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+APIPrefix+path, bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set(HeaderSliceHash, hash)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		hash  string
+		body  []byte
+		want  int
+	}{
+		{"no token", "acme-auth/1.0.0", "", hash, body, http.StatusUnauthorized},
+		{"wrong token", "acme-auth/1.0.0", "nope", hash, body, http.StatusUnauthorized},
+		{"outside namespace", "tailwind/1.0.0", "ci-secret", hash, body, http.StatusForbidden},
+		{"bad version", "acme-auth/latest", "ci-secret", hash, body, http.StatusBadRequest},
+		{"hash mismatch", "acme-auth/1.0.0", "ci-secret", hex.EncodeToString(make([]byte, 32)), body, http.StatusBadRequest},
+		{"too large", "acme-auth/1.0.0", "ci-secret", hash, make([]byte, 2048), http.StatusRequestEntityTooLarge},
+		{"published", "acme-auth/1.0.0", "ci-secret", hash, body, http.StatusCreated},
+		{"duplicate", "acme-auth/1.0.0", "admin-secret", hash, body, http.StatusConflict},
+		{"base", "next-base/2.0.0?kind=base", "admin-secret", hash, body, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if got := put(tt.path, tt.token, tt.hash, tt.body); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + ManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m models.RemoteManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Addons) != 1 || m.Addons[0].Hash != hash || len(m.Bases) != 1 {
+		t.Fatalf("manifest does not list the uploads: %+v", m)
+	}
+
+	// Rejected uploads must not leave temp files behind
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".upload-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+}
+
+func TestServerTargetPublish(t *testing.T) {
+	ts, _ := newPublishServer(t)
+	t.Setenv("SWIFTSTACK_PUBLISH_TOKEN", "ci-secret")
+
+	slice := filepath.Join(t.TempDir(), "acme-ui.tar.zst")
+	if err := os.WriteFile(slice, []byte("ui"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := NewTarget(config.PublishConfig{Backend: "server", URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := PublishRequest{
+		SlicePath: slice,
+		Kind:      "addon",
+		Slice:     models.SliceMetadata{ID: "acme-ui", Version: "0.1.0", Title: "Acme UI", Dependencies: []string{"next-base"}},
+	}
+
+	entry, err := target.Publish(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Title != "Acme UI" || entry.Size != 2 || entry.URL != ts.URL+"/acme-ui@0.1.0.tar.zst" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if _, err := target.Publish(context.Background(), req); !errors.Is(err, ErrVersionExists) {
+		t.Errorf("republishing: got %v, want ErrVersionExists", err)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Package registry provides tooling for registry maintainers.
+backend_http.go publishes to any server that accepts PUT (WebDAV, artifact
+stores, simple upload endpoints). Manifest updates use If-Match on the ETag.
+*/
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+type httpBackend struct {
+	baseURL   string
+	manifest  string
+	publicURL string
+	token     string
+	client    *http.Client
+}
+
+func newHTTPBackend(cfg config.PublishConfig, manifest string) *httpBackend {
+	return &httpBackend{
+		baseURL:   strings.TrimSuffix(cfg.URL, "/"),
+		manifest:  manifest,
+		publicURL: cfg.PublicURL,
+		token:     os.Getenv("SWIFTSTACK_PUBLISH_TOKEN"),
+		client:    utils.HTTPClient,
+	}
+}
+
+func (b *httpBackend) ManifestName() string { return b.manifest }
+
+// contentRevPrefix marks revisions derived from content because the server sent no ETag.
+const contentRevPrefix = "sha256:"
+
+func (b *httpBackend) ReadManifest(ctx context.Context) ([]byte, string, error) {
+	resp, err := b.do(ctx, http.MethodGet, joinURL(b.baseURL, b.manifest), nil, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("registry: reading manifest returned %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("registry: failed to read manifest: %w", err)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return data, etag, nil
+	}
+	return data, contentRevPrefix + contentRevision(data), nil
+}
+
+func (b *httpBackend) WriteManifest(ctx context.Context, data []byte, rev string) error {
+	headers := map[string]string{"Content-Type": "application/json"}
+	switch {
+	case rev == "":
+		headers["If-None-Match"] = "*"
+	case strings.HasPrefix(rev, contentRevPrefix):
+		// No ETag support: best effort, re-read and compare just before writing
+		_, current, err := b.ReadManifest(ctx)
+		if err != nil {
+			return err
+		}
+		if current != rev {
+			return ErrConflict
+		}
+	default:
+		headers["If-Match"] = rev
+	}
+
+	resp, err := b.do(ctx, http.MethodPut, joinURL(b.baseURL, b.manifest), bytes.NewReader(data), int64(len(data)), headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return ErrConflict
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("registry: writing manifest returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (b *httpBackend) Put(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	return b.put(ctx, name, body, size, false)
+}
+
+// Create relies on the server honoring If-None-Match: *, as WriteManifest
+// does for a new manifest.
+func (b *httpBackend) Create(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	return b.put(ctx, name, body, size, true)
+}
+
+func (b *httpBackend) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := b.do(ctx, http.MethodHead, joinURL(b.baseURL, name), nil, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, fmt.Errorf("registry: checking %s returned %d", name, resp.StatusCode)
+	}
+	return true, nil
+}
+
+func (b *httpBackend) put(ctx context.Context, name string, body io.Reader, size int64, create bool) (string, error) {
+	target := joinURL(b.baseURL, name)
+	headers := map[string]string{"Content-Type": "application/octet-stream"}
+	if create {
+		headers["If-None-Match"] = "*"
+	}
+	resp, err := b.do(ctx, http.MethodPut, target, body, size, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if create && resp.StatusCode == http.StatusPreconditionFailed {
+		return "", fmt.Errorf("%w: %s", ErrObjectExists, name)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("registry: uploading %s returned %d", name, resp.StatusCode)
+	}
+
+	if b.publicURL != "" {
+		return joinURL(b.publicURL, name), nil
+	}
+	return target, nil
+}
+
+func (b *httpBackend) do(ctx context.Context, method, url string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %s %s failed: %w", method, url, err)
+	}
+	return resp, nil
+}
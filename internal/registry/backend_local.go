@@ -0,0 +1,162 @@
+/*
+Package registry provides tooling for registry maintainers.
+backend_local.go publishes into a registry directory on a local or shared filesystem.
+*/
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// lockTimeout is how long WriteManifest waits for another publisher, and
+// also the age after which an abandoned lock file is considered stale.
+const lockTimeout = 30 * time.Second
+
+type localBackend struct {
+	dir       string
+	manifest  string
+	publicURL string
+}
+
+func (b *localBackend) ManifestName() string { return b.manifest }
+
+func (b *localBackend) ReadManifest(ctx context.Context) ([]byte, string, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir, b.manifest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("registry: failed to read manifest: %w", err)
+	}
+	return data, contentRevision(data), nil
+}
+
+func (b *localBackend) WriteManifest(ctx context.Context, data []byte, rev string) error {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return fmt.Errorf("registry: failed to create registry dir: %w", err)
+	}
+
+	unlock, err := lockFile(ctx, filepath.Join(b.dir, b.manifest+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Compare-and-swap under the lock
+	_, current, err := b.ReadManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if current != rev {
+		return ErrConflict
+	}
+	return utils.WriteFileAtomic(filepath.Join(b.dir, b.manifest), data, 0644)
+}
+
+func (b *localBackend) Put(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	return b.store(name, body, false)
+}
+
+func (b *localBackend) Create(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	return b.store(name, body, true)
+}
+
+func (b *localBackend) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(b.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("registry: %w", err)
+	}
+	return true, nil
+}
+
+// store writes the object to a temp file and moves it into place: by
+// rename, or for create by link, which fails if the name is taken.
+func (b *localBackend) store(name string, body io.Reader, create bool) (string, error) {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return "", fmt.Errorf("registry: failed to create registry dir: %w", err)
+	}
+
+	dest := filepath.Join(b.dir, name)
+	tmp, err := os.CreateTemp(b.dir, "."+name+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("registry: failed to create %s: %w", name, err)
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("registry: failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if create {
+		err = os.Link(tmp.Name(), dest)
+		os.Remove(tmp.Name())
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("%w: %s", ErrObjectExists, name)
+		}
+	} else if err = os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+	}
+	if err != nil {
+		return "", fmt.Errorf("registry: failed to store %s: %w", name, err)
+	}
+
+	if b.publicURL != "" {
+		return joinURL(b.publicURL, name), nil
+	}
+	// Relative to the manifest, which lives in the same directory
+	return name, nil
+}
+
+// contentRevision identifies a manifest version by its content.
+func contentRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// lockFile takes an exclusive lock by creating path with O_EXCL, which also
+// works on network filesystems. It returns a function that releases the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("registry: failed to lock manifest: %w", err)
+		}
+
+		// Break locks left behind by a crashed publisher
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockTimeout {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("registry: timed out waiting for manifest lock %s", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
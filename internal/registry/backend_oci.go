@@ -0,0 +1,176 @@
+/*
+Package registry provides tooling for registry maintainers.
+backend_oci.go publishes to an OCI distribution registry. Each slice version
+is an artifact <prefix>/<id>:<version>, its signature is tagged
+<version>.sig, and the manifest itself is the artifact <prefix>/registry:latest,
+which consumers sync from oci://host/<prefix>/registry:latest.
+*/
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/oci"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+)
+
+type ociBackend struct {
+	host     string
+	prefix   string
+	manifest string
+	client   *oci.Client
+}
+
+func newOCIBackend(cfg config.PublishConfig, manifest string) (*ociBackend, error) {
+	rest := strings.TrimPrefix(cfg.URL, oci.Scheme+"://")
+	host, prefix, _ := strings.Cut(rest, "/")
+	if host == "" || rest == cfg.URL {
+		return nil, fmt.Errorf("registry: the oci backend needs publish.url like oci://host/prefix")
+	}
+	return &ociBackend{
+		host:     host,
+		prefix:   strings.Trim(prefix, "/"),
+		manifest: manifest,
+		client:   oci.NewClient(),
+	}, nil
+}
+
+func (b *ociBackend) ManifestName() string { return b.manifest }
+
+// ReadManifest pulls the manifest artifact. Its layer digest is the revision.
+func (b *ociBackend) ReadManifest(ctx context.Context) ([]byte, string, error) {
+	ref, _, err := b.objectRef(b.manifest)
+	if err != nil {
+		return nil, "", err
+	}
+	layer, err := b.client.Resolve(ctx, ref)
+	if errors.Is(err, oci.ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := b.client.PullBytes(ctx, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, layer.Digest, nil
+}
+
+// WriteManifest re-tags the manifest artifact. Registries have no
+// conditional tag updates, so the revision is re-checked just before pushing.
+func (b *ociBackend) WriteManifest(ctx context.Context, data []byte, rev string) error {
+	ref, mediaType, err := b.objectRef(b.manifest)
+	if err != nil {
+		return err
+	}
+	current := ""
+	if layer, err := b.client.Resolve(ctx, ref); err == nil {
+		current = layer.Digest
+	} else if !errors.Is(err, oci.ErrNotFound) {
+		return err
+	}
+	if current != rev {
+		return ErrConflict
+	}
+
+	_, err = b.client.Push(ctx, ref, mediaType, mediaType, bytes.NewReader(data))
+	return err
+}
+
+// Put pushes an object as a single-layer artifact and returns its oci:// URL.
+func (b *ociBackend) Put(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	ref, mediaType, err := b.objectRef(name)
+	if err != nil {
+		return "", err
+	}
+
+	rs, ok := body.(io.ReadSeeker)
+	if !ok {
+		// Push reads the body twice (digest, then upload)
+		tmp, err := os.CreateTemp("", "swiftstack-oci-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, body); err != nil {
+			return "", fmt.Errorf("registry: %w", err)
+		}
+		rs = tmp
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("registry: %w", err)
+	}
+
+	artifactType := mediaType
+	if mediaType == oci.MediaTypeSliceLayer {
+		artifactType = oci.ArtifactTypeSlice
+	}
+	if _, err := b.client.Push(ctx, ref, artifactType, mediaType, rs); err != nil {
+		return "", err
+	}
+	return ref.String(), nil
+}
+
+// Create pushes an object unless its tag already exists. Registries have no
+// conditional tag updates, so the tag is checked just before pushing.
+func (b *ociBackend) Create(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	ref, _, err := b.objectRef(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := b.client.Resolve(ctx, ref); err == nil {
+		return "", fmt.Errorf("%w: %s", ErrObjectExists, ref)
+	} else if !errors.Is(err, oci.ErrNotFound) {
+		return "", err
+	}
+	return b.Put(ctx, name, body, size)
+}
+
+func (b *ociBackend) Exists(ctx context.Context, name string) (bool, error) {
+	ref, _, err := b.objectRef(name)
+	if err != nil {
+		return false, err
+	}
+	_, err = b.client.Resolve(ctx, ref)
+	if errors.Is(err, oci.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// objectRef maps a backend object name to an artifact reference:
+// id@version.tar.zst -> <prefix>/id:version, registry.json -> <prefix>/registry:latest,
+// and a trailing .sig moves to the tag.
+func (b *ociBackend) objectRef(name string) (oci.Reference, string, error) {
+	base, signature := strings.CutSuffix(name, trust.SignatureExt)
+
+	var repo, tag, mediaType string
+	if stem, ok := strings.CutSuffix(base, sliceExt); ok {
+		id, version, ok := strings.Cut(stem, "@")
+		if !ok {
+			return oci.Reference{}, "", fmt.Errorf("registry: cannot map %s to an OCI tag", name)
+		}
+		repo, tag, mediaType = id, oci.Tag(version), oci.MediaTypeSliceLayer
+	} else {
+		repo, tag, mediaType = strings.TrimSuffix(base, path.Ext(base)), "latest", oci.MediaTypeManifestJSON
+	}
+	if signature {
+		tag, mediaType = tag+trust.SignatureExt, oci.MediaTypeSignature
+	}
+	if b.prefix != "" {
+		repo = b.prefix + "/" + repo
+	}
+
+	ref, err := oci.ParseReference(oci.Scheme + "://" + b.host + "/" + strings.ToLower(repo) + ":" + tag)
+	return ref, mediaType, err
+}
@@ -0,0 +1,302 @@
+/*
+Package registry provides tooling for registry maintainers.
+backend_s3.go publishes to Amazon S3 or any S3-compatible store (MinIO,
+Ceph, R2...) using path-style requests signed with AWS Signature Version 4.
+Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and the
+optional AWS_SESSION_TOKEN.
+*/
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// unsignedPayload lets large uploads stream without hashing the body twice.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type s3Backend struct {
+	endpoint     *url.URL
+	bucket       string
+	region       string
+	prefix       string
+	manifest     string
+	publicURL    string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newS3Backend(cfg config.PublishConfig, manifest string) (*s3Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("registry: the s3 backend needs publish.bucket")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := cfg.URL
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("registry: invalid s3 endpoint %q", endpoint)
+	}
+
+	b := &s3Backend{
+		endpoint:     u,
+		bucket:       cfg.Bucket,
+		region:       region,
+		prefix:       strings.Trim(cfg.Prefix, "/"),
+		manifest:     manifest,
+		publicURL:    cfg.PublicURL,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       utils.HTTPClient,
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, fmt.Errorf("registry: the s3 backend needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return b, nil
+}
+
+func (b *s3Backend) ManifestName() string { return b.manifest }
+
+func (b *s3Backend) ReadManifest(ctx context.Context) ([]byte, string, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.manifest, nil, 0, sha256Hex(nil), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", s3Error("reading manifest", resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("registry: failed to read manifest: %w", err)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func (b *s3Backend) WriteManifest(ctx context.Context, data []byte, rev string) error {
+	headers := map[string]string{"Content-Type": "application/json"}
+	if rev == "" {
+		headers["If-None-Match"] = "*"
+	} else {
+		headers["If-Match"] = rev
+	}
+
+	resp, err := b.do(ctx, http.MethodPut, b.manifest, bytes.NewReader(data), int64(len(data)), sha256Hex(data), headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 409 is returned when a concurrent conditional write is in flight
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+		return ErrConflict
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("writing manifest", resp)
+	}
+	return nil
+}
+
+func (b *s3Backend) Put(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	return b.put(ctx, name, body, size, false)
+}
+
+func (b *s3Backend) Create(ctx context.Context, name string, body io.Reader, size int64) (string, error) {
+	return b.put(ctx, name, body, size, true)
+}
+
+func (b *s3Backend) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := b.do(ctx, http.MethodHead, name, nil, 0, sha256Hex(nil), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s3Error("checking "+name, resp)
+}
+
+func (b *s3Backend) put(ctx context.Context, name string, body io.Reader, size int64, create bool) (string, error) {
+	headers := map[string]string{"Content-Type": "application/octet-stream"}
+	if create {
+		headers["If-None-Match"] = "*"
+	}
+	resp, err := b.do(ctx, http.MethodPut, name, body, size, unsignedPayload, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// 409 is returned when a concurrent conditional write is in flight
+	if create && (resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict) {
+		return "", fmt.Errorf("%w: %s", ErrObjectExists, name)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error("uploading "+name, resp)
+	}
+
+	if b.publicURL != "" {
+		return joinURL(b.publicURL, name), nil
+	}
+	return b.objectURL(name).String(), nil
+}
+
+// objectURL returns the path-style URL of an object.
+func (b *s3Backend) objectURL(name string) *url.URL {
+	key := name
+	if b.prefix != "" {
+		key = b.prefix + "/" + name
+	}
+	u := *b.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.bucket + "/" + key
+	u.RawPath = awsEscapePath(u.Path)
+	return &u
+}
+
+func (b *s3Backend) do(ctx context.Context, method, name string, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	u := b.objectURL(name)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	b.sign(req, payloadHash, time.Now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %s %s failed: %w", method, name, err)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (b *s3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if b.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", b.sessionToken)
+	}
+
+	// Sign host, x-amz-* and the headers that change what the request does
+	signed := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "if-match" || lower == "if-none-match" ||
+			lower == "content-type" || lower == "range" {
+			signed[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+}
+
+// awsEscapePath percent-encodes everything except unreserved characters and '/'.
+func awsEscapePath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Error includes the S3 error code from the response body when available.
+func s3Error(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	code := ""
+	if i := bytes.Index(body, []byte("<Code>")); i >= 0 {
+		rest := body[i+len("<Code>"):]
+		if j := bytes.Index(rest, []byte("</Code>")); j >= 0 {
+			code = " (" + string(rest[:j]) + ")"
+		}
+	}
+	return fmt.Errorf("registry: %s returned %d%s", action, resp.StatusCode, code)
+}
@@ -0,0 +1,373 @@
+/*
+Package registry provides tooling for registry maintainers.
+bundle.go packs a stack of manifest entries and their slice blobs into a
+single file, for machines without network access, and loads such a bundle
+into the local cache and overlay manifest.
+
+A bundle is an uncompressed tar (the slices are compressed already) holding
+manifest.json, optionally manifest.json.sig, then blobs/sha256/<hash> for
+every slice with its detached signature, if the cache has one. The manifest
+comes first so its signature is checked before anything is stored.
+*/
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/gitsrc"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// BundleExt is the extension of bundle files.
+const BundleExt = ".ssb"
+
+const (
+	bundleManifest = "manifest.json"
+	bundleBlobDir  = "blobs/sha256/"
+)
+
+// BundleStack selects the entries of a bundle from m: the slice each alias
+// resolves to (see cache.FindSlice) and, transitively, the newest usable
+// version of everything they depend on. Slices built from git sources have
+// no archive to bundle and are refused.
+func BundleStack(m *models.RemoteManifest, aliases []string) (*models.RemoteManifest, error) {
+	out := &models.RemoteManifest{Bases: []models.SliceMetadata{}, Addons: []models.SliceMetadata{}}
+	seen := make(map[string]bool)
+	var add func(alias string) error
+	add = func(alias string) error {
+		s, err := cache.FindInManifest(m, alias)
+		if err != nil {
+			return fmt.Errorf("registry: %w", err)
+		}
+		ref := s.ID + "@" + s.Version
+		if seen[ref] {
+			return nil
+		}
+		seen[ref] = true
+		if gitsrc.IsSource(s.URL) {
+			return fmt.Errorf("registry: %s is built from a git source and cannot be bundled; publish it as an archive first", ref)
+		}
+		if isBase(m, s.ID) {
+			out.Bases = append(out.Bases, *s)
+		} else {
+			out.Addons = append(out.Addons, *s)
+		}
+		for _, dep := range s.Dependencies {
+			if err := add(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, alias := range aliases {
+		if err := add(alias); err != nil {
+			return nil, err
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("registry: the stack is empty")
+	}
+	return out, nil
+}
+
+func isBase(m *models.RemoteManifest, id string) bool {
+	for _, s := range m.Bases {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteBundle writes the entries of m and their blobs, which must all be in
+// the cache, as a bundle to w. With a key, the manifest is signed.
+func WriteBundle(w io.Writer, m *models.RemoteManifest, key ed25519.PrivateKey) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("registry: failed to marshal manifest: %w", err)
+	}
+	data = append(data, '\n')
+
+	tw := tar.NewWriter(w)
+	if err := writeBundleEntry(tw, bundleManifest, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if key != nil {
+		sig := trust.Sign(key, data)
+		if err := writeBundleEntry(tw, bundleManifest+trust.SignatureExt, int64(len(sig)), bytes.NewReader(sig)); err != nil {
+			return err
+		}
+	}
+
+	written := make(map[string]bool)
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			if written[s.Hash] {
+				continue
+			}
+			written[s.Hash] = true
+			path, err := cache.BlobPath(s.Hash)
+			if err != nil {
+				return fmt.Errorf("registry: %s@%s: %w", s.ID, s.Version, err)
+			}
+			if err := writeBundleFile(tw, bundleBlobDir+s.Hash, path); err != nil {
+				return fmt.Errorf("registry: %s@%s is not cached: %w", s.ID, s.Version, err)
+			}
+			if err := writeBundleFile(tw, bundleBlobDir+s.Hash+trust.SignatureExt, path+trust.SignatureExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("registry: %w", err)
+	}
+	return nil
+}
+
+func writeBundleFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeBundleEntry(tw, name, info.Size(), f)
+}
+
+func writeBundleEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("registry: %w", err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("registry: failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// BundleImport is the outcome of ImportBundle.
+type BundleImport struct {
+	Manifest *models.RemoteManifest
+	Signer   string   // the keyring entry that signed the manifest, if checked
+	Stored   int      // blobs added to the cache; the others were cached already
+	Added    []string // id@version entries new to the overlay
+	Updated  []string // id@version entries the overlay already listed
+}
+
+// ImportBundle stores the blobs of a bundle in the cache and adds its
+// entries to the local overlay manifest. The manifest signature is checked
+// like a registry manifest's: once any key is trusted, the bundle must be
+// signed by one of them. Every blob is verified against its hash.
+func ImportBundle(r io.Reader) (*BundleImport, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleManifest {
+		return nil, fmt.Errorf("registry: not a bundle: %s must come first", bundleManifest)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("registry: failed to read bundle: %w", err)
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	res := &BundleImport{Manifest: m}
+	wanted := make(map[string]bool)
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			if _, err := cache.BlobPath(s.Hash); err != nil {
+				return nil, fmt.Errorf("registry: %s@%s: %w", s.ID, s.Version, err)
+			}
+			wanted[s.Hash] = true
+		}
+	}
+
+	hdr, err = tr.Next()
+	var sig []byte
+	if err == nil && hdr.Name == bundleManifest+trust.SignatureExt {
+		if sig, err = io.ReadAll(tr); err == nil {
+			hdr, err = tr.Next()
+		}
+	}
+	signer, serr := checkBundleSignature(data, sig, err)
+	if serr != nil {
+		return nil, serr
+	}
+	res.Signer = signer
+
+	for ; err == nil; hdr, err = tr.Next() {
+		name, ok := strings.CutPrefix(hdr.Name, bundleBlobDir)
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("registry: unexpected %s in bundle", hdr.Name)
+		}
+		hash, isSig := strings.CutSuffix(name, trust.SignatureExt)
+		if !wanted[hash] {
+			return nil, fmt.Errorf("registry: bundle holds %s, which its manifest does not list", hdr.Name)
+		}
+		if isSig {
+			if err := storeBundleSignature(tr, hash); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		stored, err := storeBundleBlob(tr, hash)
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			res.Stored++
+		}
+	}
+	if err != io.EOF {
+		return nil, fmt.Errorf("registry: failed to read bundle: %w", err)
+	}
+
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			if !cache.HasBlob(s.Hash) {
+				return nil, fmt.Errorf("registry: bundle lacks the archive of %s@%s", s.ID, s.Version)
+			}
+			if err := cache.WriteRef(s.ID, s.Version, cache.Ref{Hash: s.Hash}); err != nil {
+				return nil, fmt.Errorf("registry: failed to record %s@%s: %w", s.ID, s.Version, err)
+			}
+		}
+	}
+	if err := addToOverlay(m, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// checkBundleSignature applies the manifest trust policy to a bundle. next
+// is the error of reading past the signature, which an empty bundle may hit.
+func checkBundleSignature(data, sig []byte, next error) (string, error) {
+	if next != nil && next != io.EOF {
+		return "", fmt.Errorf("registry: failed to read bundle: %w", next)
+	}
+	ring, err := trust.LoadKeyring()
+	if err != nil {
+		return "", err
+	}
+	if len(ring.Keys) == 0 {
+		return "", nil
+	}
+	if len(sig) == 0 {
+		return "", fmt.Errorf("registry: bundle is not signed, but trusted keys are configured")
+	}
+	signer, err := trust.Verify(data, sig, ring.Keys)
+	if err != nil {
+		return "", fmt.Errorf("registry: bundle signature: %w", err)
+	}
+	return signer, nil
+}
+
+// storeBundleBlob verifies the blob read from r and stores it in the cache,
+// unless it is cached already. It reports whether it stored it.
+func storeBundleBlob(r io.Reader, hash string) (bool, error) {
+	lock, err := cache.Lock("sha256-"+hash, nil)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+	if cache.HasBlob(hash) {
+		return false, nil
+	}
+
+	tmp, err := cache.TempPath()
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return false, fmt.Errorf("registry: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, fmt.Errorf("registry: failed to extract %s: %w", hash, err)
+	}
+	if err := utils.VerifyFileHash(tmp, hash); err != nil {
+		return false, fmt.Errorf("security alert: bundle: %w", err)
+	}
+	if _, err := cache.StoreBlob(tmp, hash); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storeBundleSignature keeps the detached signature of a blob, unless the
+// cache has one already. Blobs precede their signatures in a bundle.
+func storeBundleSignature(r io.Reader, hash string) error {
+	path, err := cache.BlobPath(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path + trust.SignatureExt); err == nil {
+		return nil
+	}
+	sig, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("registry: failed to read bundle: %w", err)
+	}
+	return utils.WriteFileAtomic(path+trust.SignatureExt, sig, 0644)
+}
+
+// addToOverlay lists the entries of m in the local overlay manifest,
+// replacing versions it already has.
+func addToOverlay(m *models.RemoteManifest, res *BundleImport) error {
+	path, err := config.Get().LocalRegistryPath()
+	if err != nil {
+		return err
+	}
+	overlay, err := cache.ReadManifestFile(path)
+	if err != nil {
+		return err
+	}
+	for _, kind := range []string{"base", "addon"} {
+		list := m.Addons
+		if kind == "base" {
+			list = m.Bases
+		}
+		for _, s := range list {
+			ref := s.ID + "@" + s.Version
+			if existing := findVersion(overlay, s.ID, s.Version); existing != nil {
+				*existing = s
+				res.Updated = append(res.Updated, ref)
+				continue
+			}
+			addEntry(overlay, s, kind)
+			res.Added = append(res.Added, ref)
+		}
+	}
+
+	data, err := json.MarshalIndent(overlay, "", "  ")
+	if err != nil {
+		return fmt.Errorf("registry: failed to marshal overlay: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("registry: %w", err)
+	}
+	return utils.WriteFileAtomic(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// useMachine points the cache and overlay at a fresh directory, as if on
+// another machine.
+func useMachine(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir) // keep the user's keyring out of the test
+	cfg := config.Default()
+	cfg.CacheDir = filepath.Join(dir, "cache")
+	cfg.LocalRegistry = filepath.Join(dir, "local-registry.json")
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+	return dir
+}
+
+// cacheBlob stores content as a blob and returns its hash.
+func cacheBlob(t *testing.T, content string) string {
+	t.Helper()
+	tmp, err := cache.TempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(tmp, []byte(content), 0644)
+	hash, _ := utils.HashFile(tmp)
+	if _, err := cache.StoreBlob(tmp, hash); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestBundle(t *testing.T) {
+	useMachine(t)
+	m := &models.RemoteManifest{
+		Bases: []models.SliceMetadata{
+			slice("next-base", "1.0.0", "https://cdn.example.com/a", cacheBlob(t, "base 1")),
+			slice("next-base", "2.0.0", "https://cdn.example.com/b", cacheBlob(t, "base 2")),
+		},
+		Addons: []models.SliceMetadata{
+			slice("auth", "1.0.0", "https://cdn.example.com/c", cacheBlob(t, "auth"), "session"),
+			slice("session", "1.0.0", "https://cdn.example.com/d", cacheBlob(t, "session")),
+			slice("tailwind", "1.0.0", "https://cdn.example.com/e", cacheBlob(t, "tailwind")),
+		},
+	}
+	stack, err := BundleStack(m, []string{"next-base@1.0.0", "auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stack.Bases) != 1 || stack.Bases[0].Version != "1.0.0" || len(stack.Addons) != 2 {
+		t.Fatalf("stack = %+v, want next-base@1.0.0, auth and its dependency", stack)
+	}
+
+	_, priv, _ := trust.GenerateKey()
+	var signed, unsigned bytes.Buffer
+	if err := WriteBundle(&signed, stack, priv); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBundle(&unsigned, stack, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another machine loads it into its cache and overlay
+	useMachine(t)
+	res, err := ImportBundle(bytes.NewReader(unsigned.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(res.Added)
+	if res.Stored != 3 || strings.Join(res.Added, ",") != "auth@1.0.0,next-base@1.0.0,session@1.0.0" {
+		t.Errorf("import stored %d blobs, added %v", res.Stored, res.Added)
+	}
+	if s, err := cache.FindSlice("auth"); err != nil || !cache.HasBlob(s.Hash) || s.Registry != config.LocalRegistryName {
+		t.Errorf("FindSlice(auth) after import = %+v, %v", s, err)
+	}
+	if res, err := ImportBundle(bytes.NewReader(unsigned.Bytes())); err != nil || res.Stored != 0 || len(res.Updated) != 3 {
+		t.Errorf("second import: %+v, %v", res, err)
+	}
+
+	// Once a key is trusted, only bundles signed by it are accepted
+	pub := priv.Public().(ed25519.PublicKey)
+	ring, _ := trust.LoadKeyring()
+	if err := ring.Add("team", pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportBundle(bytes.NewReader(unsigned.Bytes())); err == nil {
+		t.Error("an unsigned bundle was accepted despite a trusted key")
+	}
+	if res, err := ImportBundle(bytes.NewReader(signed.Bytes())); err != nil || res.Signer != "team" {
+		t.Errorf("signed import: %+v, %v", res, err)
+	}
+
+	// An archive that does not match its hash is refused
+	useMachine(t)
+	hash := cacheBlob(t, "genuine")
+	path, _ := cache.BlobPath(hash)
+	os.WriteFile(path, []byte("tampered"), 0644)
+	var tampered bytes.Buffer
+	bad := &models.RemoteManifest{Bases: []models.SliceMetadata{slice("evil", "1.0.0", "https://cdn.example.com/f", hash)}}
+	if err := WriteBundle(&tampered, bad, nil); err != nil {
+		t.Fatal(err)
+	}
+	useMachine(t)
+	if _, err := ImportBundle(&tampered); err == nil || !strings.Contains(err.Error(), "security alert") {
+		t.Errorf("tampered bundle: %v", err)
+	}
+	if _, err := cache.FindSlice("evil"); err == nil {
+		t.Error("a refused bundle must not reach the overlay")
+	}
+}
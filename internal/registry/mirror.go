@@ -0,0 +1,329 @@
+/*
+Package registry provides tooling for registry maintainers.
+mirror.go copies a manifest and every slice it references into a
+self-contained registry directory for networks without internet access:
+
+	<dir>/registry.json               manifest with relative slice URLs
+	<dir>/slices/<id>@<version>.tar.zst  (+ .sig when the source had one)
+	<dir>/.mirror.json                what each slice was copied from
+
+Mirroring into an existing mirror only downloads what changed upstream.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/gitsrc"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// MirrorStateFile records the upstream of every mirrored slice.
+const MirrorStateFile = ".mirror.json"
+
+const (
+	mirrorManifest = "registry.json"
+	mirrorSliceDir = "slices"
+)
+
+// MirrorOptions controls Mirror.
+type MirrorOptions struct {
+	Dir string
+	// Filter lists id patterns (path.Match syntax, e.g. "next-*"). Empty
+	// mirrors every slice. Dependencies of matching slices are always included.
+	Filter []string
+	Chunks int
+	// Progress, if set, is called before each slice is downloaded.
+	Progress func(ref string)
+}
+
+// MirrorResult lists what a mirror run did, as id@version references.
+type MirrorResult struct {
+	Downloaded []string
+	Unchanged  []string
+	Removed    []string
+	Manifest   *models.RemoteManifest
+}
+
+// MirrorState is stored in the mirror as MirrorStateFile.
+type MirrorState struct {
+	Filter []string               `json:"filter,omitempty"`
+	Slices map[string]MirrorEntry `json:"slices"`
+}
+
+// MirrorEntry is the upstream a mirrored slice was copied from.
+type MirrorEntry struct {
+	URL    string `json:"url"`
+	Hash   string `json:"hash,omitempty"`   // the upstream hash field
+	Commit string `json:"commit,omitempty"` // resolved commit of git sources
+	SHA256 string `json:"sha256"`           // of the mirrored file
+}
+
+// ReadMirrorState loads the state of the mirror in dir. A directory that
+// is not a mirror yet yields an empty state.
+func ReadMirrorState(dir string) (*MirrorState, error) {
+	state := &MirrorState{Slices: make(map[string]MirrorEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, MirrorStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("registry: invalid mirror state in %s: %w", dir, err)
+	}
+	if state.Slices == nil {
+		state.Slices = make(map[string]MirrorEntry)
+	}
+	return state, nil
+}
+
+// FilterManifest keeps the slices whose ids match one of the patterns, plus
+// everything they depend on. An empty filter keeps everything.
+func FilterManifest(m *models.RemoteManifest, patterns []string) (*models.RemoteManifest, error) {
+	if len(patterns) == 0 {
+		return m, nil
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("registry: invalid filter %q: %w", p, err)
+		}
+	}
+
+	deps := make(map[string][]string)
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			deps[s.ID] = append(deps[s.ID], s.Dependencies...)
+		}
+	}
+
+	keep := make(map[string]bool)
+	var visit func(id string)
+	visit = func(id string) {
+		if keep[id] {
+			return
+		}
+		keep[id] = true
+		for _, d := range deps[id] {
+			visit(d)
+		}
+	}
+	for id := range deps {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, id); ok {
+				visit(id)
+			}
+		}
+	}
+
+	out := &models.RemoteManifest{Bases: []models.SliceMetadata{}, Addons: []models.SliceMetadata{}}
+	for _, s := range m.Bases {
+		if keep[s.ID] {
+			out.Bases = append(out.Bases, s)
+		}
+	}
+	for _, s := range m.Addons {
+		if keep[s.ID] {
+			out.Addons = append(out.Addons, s)
+		}
+	}
+	return out, nil
+}
+
+// Mirror copies the slices of m into opts.Dir, verifying each one, and
+// writes a manifest whose URLs are relative to it. Slices already mirrored
+// from the same upstream URL and hash are kept; slices no longer listed are
+// deleted. When any download fails, the previous manifest stays in place
+// and the error lists every failure; finished downloads are kept for the
+// next run.
+func Mirror(m *models.RemoteManifest, opts MirrorOptions) (MirrorResult, error) {
+	var res MirrorResult
+
+	selected, err := FilterManifest(m, opts.Filter)
+	if err != nil {
+		return res, err
+	}
+	if err := os.MkdirAll(filepath.Join(opts.Dir, mirrorSliceDir), 0755); err != nil {
+		return res, fmt.Errorf("registry: %w", err)
+	}
+	state, err := ReadMirrorState(opts.Dir)
+	if err != nil {
+		return res, err
+	}
+
+	next := &MirrorState{Filter: opts.Filter, Slices: make(map[string]MirrorEntry)}
+	out := &models.RemoteManifest{Bases: []models.SliceMetadata{}, Addons: []models.SliceMetadata{}}
+	var failures []string
+
+	mirrorList := func(list []models.SliceMetadata) []models.SliceMetadata {
+		var mirrored []models.SliceMetadata
+		for _, s := range list {
+			ref := sliceRef(s)
+			entry, err := mirrorSlice(&s, state.Slices[ref], opts, &res)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", ref, err))
+				continue
+			}
+			next.Slices[ref] = entry
+			mirrored = append(mirrored, s)
+		}
+		return mirrored
+	}
+	out.Bases = append(out.Bases, mirrorList(selected.Bases)...)
+	out.Addons = append(out.Addons, mirrorList(selected.Addons)...)
+
+	if len(failures) > 0 {
+		return res, fmt.Errorf("registry: %d slice(s) could not be mirrored:\n  %s", len(failures), strings.Join(failures, "\n  "))
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return res, err
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(opts.Dir, mirrorManifest), append(data, '\n'), 0644); err != nil {
+		return res, fmt.Errorf("registry: failed to write mirror manifest: %w", err)
+	}
+	// Any signature of the upstream manifest does not cover the rewritten URLs
+	os.Remove(filepath.Join(opts.Dir, mirrorManifest+trust.SignatureExt))
+
+	if data, err = json.MarshalIndent(next, "", "  "); err == nil {
+		err = utils.WriteFileAtomic(filepath.Join(opts.Dir, MirrorStateFile), data, 0644)
+	}
+	if err != nil {
+		return res, fmt.Errorf("registry: failed to write mirror state: %w", err)
+	}
+
+	res.Removed, err = pruneMirror(opts.Dir, state, next)
+	res.Manifest = out
+	return res, err
+}
+
+// mirrorSlice makes sure s is in the mirror and rewrites its URL (and, for
+// git sources, its hash) to point at the mirrored file.
+func mirrorSlice(s *models.SliceMetadata, prev MirrorEntry, opts MirrorOptions, res *MirrorResult) (MirrorEntry, error) {
+	ref := sliceRef(*s)
+	name := mirrorFileName(*s)
+	rel := mirrorSliceDir + "/" + name
+	dest := filepath.Join(opts.Dir, mirrorSliceDir, name)
+	entry := MirrorEntry{URL: s.URL, Hash: s.Hash}
+
+	var src gitsrc.Source
+	isGit := gitsrc.IsSource(s.URL)
+	if isGit {
+		var err error
+		if src, err = gitsrc.Parse(s.URL); err != nil {
+			return entry, err
+		}
+		if entry.Commit, err = gitsrc.Resolve(context.Background(), src); err != nil {
+			return entry, err
+		}
+		if s.Hash != "" && s.Hash != entry.Commit {
+			return entry, fmt.Errorf("%s resolves to commit %s, but the hash pins %s", src.Ref, entry.Commit, s.Hash)
+		}
+	}
+
+	// Unchanged upstream and intact on disk: nothing to download
+	entry.SHA256 = prev.SHA256
+	if prev.URL != "" && prev == entry {
+		if hash, err := utils.HashFile(dest); err == nil && hash == prev.SHA256 {
+			res.Unchanged = append(res.Unchanged, ref)
+			s.URL, s.Hash = rel, hash
+			return entry, nil
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(ref)
+	}
+	tmp := dest + ".part"
+
+	var err error
+	if isGit {
+		err = gitsrc.Pack(context.Background(), src, entry.Commit, tmp)
+	} else if err = utils.Download(s.URL, tmp, opts.Chunks); err != nil {
+		// Keep the partial download for the next run to resume
+		return entry, err
+	} else {
+		err = utils.VerifyFileHash(tmp, s.Hash)
+	}
+	defer os.Remove(tmp)
+	if err != nil {
+		os.Remove(tmp + utils.PartialSuffix)
+		return entry, err
+	}
+	hash, err := utils.HashFile(tmp)
+	if err != nil {
+		return entry, err
+	}
+
+	// Carry a detached signature along so trust policies keep working
+	if !isGit {
+		sig, err := utils.ReadResource(s.URL + trust.SignatureExt)
+		switch {
+		case err == nil:
+			if err := utils.WriteFileAtomic(dest+trust.SignatureExt, sig, 0644); err != nil {
+				return entry, err
+			}
+		case errors.Is(err, utils.ErrNotFound):
+			os.Remove(dest + trust.SignatureExt)
+		default:
+			return entry, fmt.Errorf("failed to fetch signature: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return entry, err
+	}
+
+	res.Downloaded = append(res.Downloaded, ref)
+	entry.SHA256 = hash
+	s.URL, s.Hash = rel, hash
+	return entry, nil
+}
+
+// mirrorFileName is the file a slice is stored as: id@version.tar.zst.
+func mirrorFileName(s models.SliceMetadata) string {
+	name := s.ID + "@" + s.Version + sliceExt
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+}
+
+// pruneMirror deletes the slices (and signatures) of references that were
+// in the previous state but are not in the next one.
+func pruneMirror(dir string, prev, next *MirrorState) ([]string, error) {
+	keep := make(map[string]bool)
+	for ref := range next.Slices {
+		id, version, _ := strings.Cut(ref, "@")
+		keep[mirrorFileName(models.SliceMetadata{ID: id, Version: version})] = true
+	}
+
+	var removed []string
+	for ref := range prev.Slices {
+		if _, ok := next.Slices[ref]; ok {
+			continue
+		}
+		id, version, _ := strings.Cut(ref, "@")
+		name := mirrorFileName(models.SliceMetadata{ID: id, Version: version})
+		if keep[name] {
+			continue
+		}
+		for _, p := range []string{name, name + trust.SignatureExt} {
+			err := os.Remove(filepath.Join(dir, mirrorSliceDir, p))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return removed, fmt.Errorf("registry: %w", err)
+			}
+		}
+		removed = append(removed, ref)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
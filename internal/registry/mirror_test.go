@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+func TestMirror(t *testing.T) {
+	ts, _ := newTestRegistry(t)
+	data, err := utils.FetchRemote(ts.URL + ManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := filepath.Join(t.TempDir(), "registry.json")
+	os.WriteFile(upstream, data, 0644)
+	m, err := LoadFile(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	mirror := func(m *models.RemoteManifest, filter ...string) MirrorResult {
+		t.Helper()
+		res, err := Mirror(m, MirrorOptions{Dir: dir, Filter: filter, Chunks: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name       string
+		filter     []string
+		downloaded int
+		unchanged  int
+		removed    []string
+	}{
+		{"first run", nil, 2, 0, nil},
+		{"nothing changed", nil, 0, 2, nil},
+		{"filter keeps dependencies", []string{"tail*"}, 0, 2, nil},
+		{"filter drops an addon", []string{"next-*"}, 0, 1, []string{"tailwind@2.1.0"}},
+		{"addon comes back", nil, 1, 1, nil},
+	}
+	for _, tt := range tests {
+		res := mirror(m, tt.filter...)
+		sort.Strings(res.Removed)
+		if len(res.Downloaded) != tt.downloaded || len(res.Unchanged) != tt.unchanged ||
+			strings.Join(res.Removed, ",") != strings.Join(tt.removed, ",") {
+			t.Errorf("%s: downloaded %v, unchanged %v, removed %v", tt.name, res.Downloaded, res.Unchanged, res.Removed)
+		}
+	}
+
+	// The result is a valid, self-contained registry
+	local, err := LoadFile(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := Validate(local); len(issues) > 0 {
+		t.Errorf("mirror manifest has issues: %v", issues)
+	}
+	if issues := VerifyDownloads(local, filepath.Join(dir, "registry.json"), 1, nil); len(issues) > 0 {
+		t.Errorf("mirror downloads: %v", issues)
+	}
+	if u := local.Bases[0].URL; u != "slices/next-base@1.0.0.tar.zst" {
+		t.Errorf("base URL = %s, want a relative path", u)
+	}
+
+	// A bad upstream hash fails the run and leaves the mirror as it was
+	bad := *m
+	bad.Addons = append([]models.SliceMetadata{}, m.Addons...)
+	bad.Addons[0].Version, bad.Addons[0].Hash = "2.2.0", goodHash
+	before, _ := os.ReadFile(filepath.Join(dir, "registry.json"))
+	if _, err := Mirror(&bad, MirrorOptions{Dir: dir, Chunks: 1}); err == nil || !strings.Contains(err.Error(), "tailwind@2.2.0") {
+		t.Errorf("mirror with a bad hash: %v", err)
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, "registry.json")); string(after) != string(before) {
+		t.Error("a failed mirror run replaced the manifest")
+	}
+}
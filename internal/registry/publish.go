@@ -0,0 +1,312 @@
+/*
+Package registry provides tooling for registry maintainers.
+publish.go uploads slices to a storage backend and records them in the
+backend's manifest using optimistic concurrency, so two publishers never
+silently overwrite each other's entries.
+*/
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+	"github.com/blang/semver/v4"
+)
+
+// ErrConflict is returned by Backend.WriteManifest when the manifest changed
+// since it was read.
+var ErrConflict = errors.New("registry: manifest was modified concurrently")
+
+// ErrVersionExists is returned when publishing an id@version that is already listed.
+var ErrVersionExists = errors.New("registry: version already published")
+
+// ErrObjectExists is returned by Backend.Create when the object is already stored.
+var ErrObjectExists = errors.New("registry: object already exists")
+
+// Backend stores slice artifacts and the manifest that lists them.
+type Backend interface {
+	// ReadManifest returns the current manifest bytes (nil if none exists yet)
+	// and an opaque revision used for the conditional write.
+	ReadManifest(ctx context.Context) ([]byte, string, error)
+	// WriteManifest replaces the manifest only if it is still at revision rev
+	// ("" meaning it must not exist yet); otherwise it returns ErrConflict.
+	WriteManifest(ctx context.Context, data []byte, rev string) error
+	// Put uploads an object and returns the URL consumers should download it from.
+	Put(ctx context.Context, name string, body io.Reader, size int64) (string, error)
+	// Create is Put for an object that must not exist yet: it returns
+	// ErrObjectExists instead of replacing one.
+	Create(ctx context.Context, name string, body io.Reader, size int64) (string, error)
+	// Exists reports whether an object is stored.
+	Exists(ctx context.Context, name string) (bool, error)
+	// ManifestName is the object name of the manifest (e.g. registry.json).
+	ManifestName() string
+}
+
+// Target is anything 'swiftstack publish' can publish to: a storage backend
+// whose manifest the client updates, or a registry server that updates its own.
+type Target interface {
+	Publish(ctx context.Context, req PublishRequest) (*models.SliceMetadata, error)
+}
+
+// NewTarget creates the publish target described by the configuration.
+func NewTarget(cfg config.PublishConfig) (Target, error) {
+	if cfg.Backend == "server" {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("registry: the server backend needs publish.url")
+		}
+		return newServerClient(cfg), nil
+	}
+
+	b, err := NewBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return storeTarget{b}, nil
+}
+
+// storeTarget publishes into a Backend, updating its manifest client-side.
+type storeTarget struct{ Backend }
+
+func (t storeTarget) Publish(ctx context.Context, req PublishRequest) (*models.SliceMetadata, error) {
+	return Publish(ctx, t.Backend, req)
+}
+
+// NewBackend creates the storage backend described by the publish configuration.
+func NewBackend(cfg config.PublishConfig) (Backend, error) {
+	manifest := cfg.Manifest
+	if manifest == "" {
+		manifest = "registry.json"
+	}
+
+	switch cfg.Backend {
+	case "local":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("registry: the local backend needs publish.path")
+		}
+		return &localBackend{dir: cfg.Path, manifest: manifest, publicURL: cfg.PublicURL}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("registry: the http backend needs publish.url")
+		}
+		return newHTTPBackend(cfg, manifest), nil
+	case "s3":
+		return newS3Backend(cfg, manifest)
+	case "oci":
+		return newOCIBackend(cfg, manifest)
+	case "":
+		return nil, fmt.Errorf("registry: no publish backend configured (set publish.backend or use --to)")
+	default:
+		return nil, fmt.Errorf("registry: unsupported publish backend %q", cfg.Backend)
+	}
+}
+
+// PublishRequest describes a slice to publish.
+type PublishRequest struct {
+	// SlicePath is the built .tar.zst artifact.
+	SlicePath string
+	// Slice carries id, version and the descriptive fields; URL, hash and size are filled in.
+	Slice models.SliceMetadata
+	// Kind is "base" or "addon". Existing ids keep their kind.
+	Kind string
+	// SignaturePath optionally points at a detached slice signature to upload alongside.
+	SignaturePath string
+	// ManifestKey, when set, re-signs the updated manifest (uploaded as <manifest>.sig).
+	ManifestKey ed25519.PrivateKey
+}
+
+// maxManifestAttempts bounds the read-modify-write retries on concurrent publishes.
+const maxManifestAttempts = 5
+
+// Publish uploads the slice and adds it to the backend's manifest.
+// It refuses to replace an id@version that is already listed.
+func Publish(ctx context.Context, b Backend, req PublishRequest) (*models.SliceMetadata, error) {
+	entry := req.Slice
+	if entry.ID == "" || entry.Version == "" {
+		return nil, fmt.Errorf("registry: id and version are required")
+	}
+	if _, err := semver.Parse(entry.Version); err != nil {
+		return nil, fmt.Errorf("registry: version '%s' is not valid semver: %w", entry.Version, err)
+	}
+
+	hash, err := utils.HashFile(req.SlicePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(req.SlicePath)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	entry.Hash = hash
+	entry.Size = info.Size()
+
+	// Fail fast before uploading anything
+	data, _, err := b.ReadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if findVersion(m, entry.ID, entry.Version) != nil {
+		return nil, fmt.Errorf("%w: %s@%s", ErrVersionExists, entry.ID, entry.Version)
+	}
+	// A signature left next to the manifest would no longer match it, and
+	// every consumer that trusts a key would fail to sync
+	if req.ManifestKey == nil {
+		sigName := b.ManifestName() + trust.SignatureExt
+		signed, err := b.Exists(ctx, sigName)
+		if err != nil {
+			return nil, err
+		}
+		if signed {
+			return nil, fmt.Errorf("registry: the manifest is signed (%s exists); publish with the manifest key so it is signed again", sigName)
+		}
+	}
+
+	// 1. Upload the artifact (and its signature). It is never replaced: a
+	// concurrent publish of the same version that loses the manifest update
+	// must not swap the bytes whose hash the winner recorded.
+	name := fmt.Sprintf("%s@%s.tar.zst", entry.ID, entry.Version)
+	f, err := os.Open(req.SlicePath)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	entry.URL, err = b.Create(ctx, name, f, info.Size())
+	f.Close()
+	if errors.Is(err, ErrObjectExists) {
+		return nil, fmt.Errorf("%w: %s is stored but not listed; another publish of %s@%s is running, or one failed midway and %s must be removed first",
+			ErrVersionExists, name, entry.ID, entry.Version, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.SignaturePath != "" {
+		sig, err := os.ReadFile(req.SignaturePath)
+		if err != nil {
+			return nil, fmt.Errorf("registry: failed to read signature: %w", err)
+		}
+		if _, err := b.Put(ctx, name+trust.SignatureExt, bytes.NewReader(sig), int64(len(sig))); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. Record it in the manifest, retrying if someone else published meanwhile
+	for attempt := 1; ; attempt++ {
+		data, rev, err := b.ReadManifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m, err := parseManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		if findVersion(m, entry.ID, entry.Version) != nil {
+			return nil, fmt.Errorf("%w: %s@%s", ErrVersionExists, entry.ID, entry.Version)
+		}
+
+		addEntry(m, entry, req.Kind)
+
+		out, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("registry: failed to marshal manifest: %w", err)
+		}
+		out = append(out, '\n')
+
+		err = b.WriteManifest(ctx, out, rev)
+		if errors.Is(err, ErrConflict) && attempt < maxManifestAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if req.ManifestKey != nil {
+			sig := trust.Sign(req.ManifestKey, out)
+			if _, err := b.Put(ctx, b.ManifestName()+trust.SignatureExt, bytes.NewReader(sig), int64(len(sig))); err != nil {
+				return nil, fmt.Errorf("registry: manifest updated but signing failed: %w", err)
+			}
+		}
+		return &entry, nil
+	}
+}
+
+// addEntry appends a new version, inheriting descriptive fields and the
+// base/addon kind from earlier versions of the same id.
+func addEntry(m *models.RemoteManifest, entry models.SliceMetadata, kind string) {
+	list := &m.Addons
+	if kind == "base" {
+		list = &m.Bases
+	}
+
+	for _, l := range []*[]models.SliceMetadata{&m.Bases, &m.Addons} {
+		for _, existing := range *l {
+			if existing.ID != entry.ID {
+				continue
+			}
+			list = l
+			if entry.Title == "" {
+				entry.Title = existing.Title
+			}
+			if entry.Description == "" {
+				entry.Description = existing.Description
+			}
+			if entry.Dependencies == nil {
+				entry.Dependencies = existing.Dependencies
+			}
+			if entry.Category == "" {
+				entry.Category = existing.Category
+			}
+			if entry.Tags == nil {
+				entry.Tags = existing.Tags
+			}
+			if entry.CompatibleBases == nil {
+				entry.CompatibleBases = existing.CompatibleBases
+			}
+		}
+	}
+	if entry.Title == "" {
+		entry.Title = entry.ID
+	}
+
+	*list = append(*list, entry)
+}
+
+func findVersion(m *models.RemoteManifest, id, version string) *models.SliceMetadata {
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for i := range list {
+			if list[i].ID == id && list[i].Version == version {
+				return &list[i]
+			}
+		}
+	}
+	return nil
+}
+
+func parseManifest(data []byte) (*models.RemoteManifest, error) {
+	m := &models.RemoteManifest{Bases: []models.SliceMetadata{}, Addons: []models.SliceMetadata{}}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("registry: existing manifest is not valid JSON: %w", err)
+	}
+	return m, nil
+}
+
+// joinURL appends an object name to a base URL.
+func joinURL(base, name string) string {
+	return strings.TrimSuffix(base, "/") + "/" + name
+}
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/oci/ocitest"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+func TestPublishLocal(t *testing.T) {
+	dir := t.TempDir()
+	slicePath := filepath.Join(t.TempDir(), "ui.tar.zst")
+	if err := os.WriteFile(slicePath, []byte("slice"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &localBackend{dir: dir, manifest: "registry.json"}
+	ctx := context.Background()
+	publish := func(version string) error {
+		_, err := Publish(ctx, b, PublishRequest{
+			SlicePath: slicePath,
+			Kind:      "addon",
+			Slice:     models.SliceMetadata{ID: "ui", Version: version, Title: "UI Kit"},
+		})
+		return err
+	}
+
+	if err := publish("1.0.0"); err != nil {
+		t.Fatalf("first publish: %v", err)
+	}
+	if err := publish("1.0.0"); !errors.Is(err, ErrVersionExists) {
+		t.Fatalf("republish error = %v; want ErrVersionExists", err)
+	}
+	if err := publish("1.1.0"); err != nil {
+		t.Fatalf("second version: %v", err)
+	}
+	// An artifact left by a publish that never got listed is not replaced
+	leftover := filepath.Join(dir, "ui@1.2.0.tar.zst")
+	if err := os.WriteFile(leftover, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := publish("1.2.0"); !errors.Is(err, ErrVersionExists) {
+		t.Fatalf("publish over an unlisted artifact error = %v; want ErrVersionExists", err)
+	}
+	if data, _ := os.ReadFile(leftover); string(data) != "other" {
+		t.Errorf("unlisted artifact was replaced with %q", data)
+	}
+	if err := publish("latest"); err == nil || !strings.Contains(err.Error(), "semver") {
+		t.Errorf("publish of a non-semver version error = %v; want a semver error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ui@latest.tar.zst")); err == nil {
+		t.Error("a non-semver version was uploaded")
+	}
+
+	m, err := LoadFile(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Addons) != 2 {
+		t.Fatalf("manifest has %d addons; want 2", len(m.Addons))
+	}
+	got := m.Addons[1]
+	if got.URL != "ui@1.1.0.tar.zst" || got.Title != "UI Kit" || got.Size != 5 || len(got.Hash) != 64 {
+		t.Errorf("unexpected entry %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ui@1.1.0.tar.zst")); err != nil {
+		t.Errorf("artifact not stored: %v", err)
+	}
+
+	// A signed manifest is only updated together with its signature
+	sigPath := filepath.Join(dir, "registry.json.sig")
+	os.WriteFile(sigPath, []byte("old signature"), 0644)
+	if err := publish("1.3.0"); err == nil || !strings.Contains(err.Error(), "manifest is signed") {
+		t.Fatalf("publish without the manifest key error = %v; want a refusal", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ui@1.3.0.tar.zst")); err == nil {
+		t.Error("the artifact was uploaded although the publish was refused")
+	}
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	_, err = Publish(ctx, b, PublishRequest{
+		SlicePath:   slicePath,
+		Kind:        "addon",
+		Slice:       models.SliceMetadata{ID: "ui", Version: "1.3.0"},
+		ManifestKey: priv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "registry.json"))
+	sig, _ := os.ReadFile(sigPath)
+	key := trust.TrustedKey{Name: "publisher", PublicKey: trust.EncodePublicKey(pub)}
+	if _, err := trust.Verify(data, sig, []trust.TrustedKey{key}); err != nil {
+		t.Error("the manifest signature was not replaced")
+	}
+}
+
+func TestLocalWriteManifestConflict(t *testing.T) {
+	b := &localBackend{dir: t.TempDir(), manifest: "registry.json"}
+	ctx := context.Background()
+
+	if err := b.WriteManifest(ctx, []byte(`{"bases":[]}`), ""); err != nil {
+		t.Fatal(err)
+	}
+	// A writer that still believes the manifest doesn't exist must lose
+	if err := b.WriteManifest(ctx, []byte(`{"addons":[]}`), ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("stale write error = %v; want ErrConflict", err)
+	}
+}
+
+func TestPublishOCI(t *testing.T) {
+	reg := ocitest.NewRegistry()
+	defer reg.Close()
+
+	slice := filepath.Join(t.TempDir(), "next-base.tar.zst")
+	if err := os.WriteFile(slice, []byte("base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBackend(config.PublishConfig{Backend: "oci", URL: "oci://" + reg.Host() + "/slices"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := PublishRequest{SlicePath: slice, Kind: "base", Slice: models.SliceMetadata{ID: "next-base", Version: "1.0.0"}}
+	entry, err := Publish(context.Background(), b, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.URL != "oci://"+reg.Host()+"/slices/next-base:1.0.0" {
+		t.Errorf("unexpected URL %s", entry.URL)
+	}
+
+	// Consumers sync the manifest artifact and download through the oci fetcher
+	data, err := utils.ReadResource("oci://" + reg.Host() + "/slices/registry:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := parseManifest(data)
+	if err != nil || len(m.Bases) != 1 || m.Bases[0].Hash != entry.Hash {
+		t.Fatalf("manifest artifact %s: %v", data, err)
+	}
+	dest := filepath.Join(t.TempDir(), "pulled.tar.zst")
+	if err := utils.Download(entry.URL, dest, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.VerifyFileHash(dest, entry.Hash); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := Publish(context.Background(), b, req); !errors.Is(err, ErrVersionExists) {
+		t.Errorf("republishing: got %v, want ErrVersionExists", err)
+	}
+}
@@ -0,0 +1,394 @@
+/*
+Package registry provides tooling for registry maintainers.
+server.go serves a directory of .tar.zst slices over HTTP together with a
+manifest generated from them. Slices support HEAD, Range and ETag requests,
+which the concurrent downloader relies on. When tokens are configured the
+server also accepts uploads (see api.go).
+*/
+package registry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/trust"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// ManifestPath is where the server publishes the generated manifest.
+const ManifestPath = "/registry.json"
+
+const sliceExt = ".tar.zst"
+
+// ServerOptions configures a registry server.
+type ServerOptions struct {
+	// Dir holds the slices. Files under Dir/bases/ are listed as bases,
+	// everything else as addons unless metadata says otherwise.
+	Dir string
+	// PublicURL prefixes slice URLs in the manifest. When empty, URLs are
+	// built from the scheme and Host of each manifest request.
+	PublicURL string
+	// SignKey, when set, signs the generated manifest (served at registry.json.sig).
+	SignKey ed25519.PrivateKey
+	// Tokens enable the publish API. Without tokens the server is read-only.
+	Tokens []Token
+	// MaxUploadSize caps uploads (DefaultMaxUploadSize when zero).
+	MaxUploadSize int64
+}
+
+// Server is an http.Handler serving a slice directory as a registry.
+type Server struct {
+	opts ServerOptions
+
+	mu     sync.Mutex
+	hashes map[string]hashEntry // relative path -> cached hash
+
+	// publishMu is held for writing while an upload is moved into place, so
+	// the generated manifest never shows a half-committed slice.
+	publishMu sync.RWMutex
+}
+
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// servedSlice is a slice file found in the directory.
+type servedSlice struct {
+	kind string // "base" or "addon"
+	rel  string // slash-separated path relative to Dir
+	meta models.SliceMetadata
+}
+
+// sliceSidecar is the optional <id>@<version>.json metadata next to a slice.
+type sliceSidecar struct {
+	models.SliceMetadata
+	Kind string `json:"kind,omitempty"`
+}
+
+// NewServer creates a server for the given directory.
+func NewServer(opts ServerOptions) (*Server, error) {
+	info, err := os.Stat(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("registry: %s is not a directory", opts.Dir)
+	}
+	return &Server{opts: opts, hashes: make(map[string]hashEntry)}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, APIPrefix) {
+		s.servePublish(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case ManifestPath, "/manifest.json":
+		s.serveManifest(w, r, false)
+		return
+	case ManifestPath + trust.SignatureExt, "/manifest.json" + trust.SignatureExt:
+		s.serveManifest(w, r, true)
+		return
+	}
+
+	s.serveSlice(w, r)
+}
+
+// serveManifest renders the manifest (or its signature) for this request.
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, signature bool) {
+	data, err := s.Manifest(s.baseURL(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if signature {
+		if s.opts.SignKey == nil {
+			http.NotFound(w, r)
+			return
+		}
+		data = trust.Sign(s.opts.SignKey, data)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.Header().Set("ETag", `"`+contentRevision(data)+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// serveSlice serves a slice or its detached signature from the directory.
+func (s *Server) serveSlice(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if !strings.HasSuffix(rel, sliceExt) && !strings.HasSuffix(rel, sliceExt+trust.SignatureExt) {
+		http.NotFound(w, r)
+		return
+	}
+
+	full := filepath.Join(s.opts.Dir, filepath.FromSlash(rel))
+	if !isWithin(s.opts.Dir, full) || isHidden(rel) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if strings.HasSuffix(rel, sliceExt) {
+		hash, err := s.hashOf(rel, full, info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// A strong ETag lets clients validate ranges with If-Range
+		w.Header().Set("ETag", `"`+hash+`"`)
+		w.Header().Set("Content-Type", "application/zstd")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// Manifest scans the directory and renders the manifest with slice URLs under baseURL.
+func (s *Server) Manifest(baseURL string) ([]byte, error) {
+	s.publishMu.RLock()
+	slices, err := s.scan()
+	s.publishMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	m := models.RemoteManifest{Bases: []models.SliceMetadata{}, Addons: []models.SliceMetadata{}}
+	for _, sl := range slices {
+		entry := sl.meta
+		entry.URL = joinURL(baseURL, escapePath(sl.rel))
+		if sl.kind == "base" {
+			m.Bases = append(m.Bases, entry)
+		} else {
+			m.Addons = append(m.Addons, entry)
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("registry: failed to marshal manifest: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// scan walks the directory for id@version.tar.zst files.
+func (s *Server) scan() ([]servedSlice, error) {
+	// Entries of a registry.json in the directory (e.g. from 'swiftstack
+	// publish --to <dir>') provide titles, descriptions and kinds.
+	known := make(map[string]sliceSidecar)
+	if m, err := LoadFile(filepath.Join(s.opts.Dir, "registry.json")); err == nil {
+		for _, b := range m.Bases {
+			known[b.ID+"@"+b.Version] = sliceSidecar{SliceMetadata: b, Kind: "base"}
+		}
+		for _, a := range m.Addons {
+			known[a.ID+"@"+a.Version] = sliceSidecar{SliceMetadata: a, Kind: "addon"}
+		}
+	}
+
+	var slices []servedSlice
+	err := filepath.WalkDir(s.opts.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != s.opts.Dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), sliceExt) || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		id, version, ok := strings.Cut(strings.TrimSuffix(d.Name(), sliceExt), "@")
+		if !ok || id == "" || version == "" {
+			return nil // not named id@version.tar.zst
+		}
+
+		rel, err := filepath.Rel(s.opts.Dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hash, err := s.hashOf(rel, p, info)
+		if err != nil {
+			return err
+		}
+
+		sl := servedSlice{kind: "addon", rel: rel}
+		if strings.HasPrefix(rel, "bases/") {
+			sl.kind = "base"
+		}
+		meta := sliceSidecar{SliceMetadata: models.SliceMetadata{Title: id}}
+		if k, ok := known[id+"@"+version]; ok {
+			meta = k
+		}
+		if data, err := os.ReadFile(strings.TrimSuffix(p, sliceExt) + ".json"); err == nil {
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return fmt.Errorf("registry: invalid metadata for %s: %w", rel, err)
+			}
+		}
+		if meta.Kind == "base" || meta.Kind == "addon" {
+			sl.kind = meta.Kind
+		}
+
+		sl.meta = meta.SliceMetadata
+		sl.meta.ID, sl.meta.Version = id, version
+		sl.meta.Hash, sl.meta.Size = hash, info.Size()
+		slices = append(slices, sl)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("registry: failed to scan %s: %w", s.opts.Dir, err)
+	}
+
+	sort.Slice(slices, func(i, j int) bool { return slices[i].rel < slices[j].rel })
+	return slices, nil
+}
+
+// hashOf returns the SHA-256 of a slice, re-hashing only when it changed on disk.
+func (s *Server) hashOf(rel, full string, info fs.FileInfo) (string, error) {
+	s.mu.Lock()
+	cached, ok := s.hashes[rel]
+	s.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.hash, nil
+	}
+
+	hash, err := utils.HashFile(full)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.hashes[rel] = hashEntry{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	s.mu.Unlock()
+	return hash, nil
+}
+
+func (s *Server) baseURL(r *http.Request) string {
+	if s.opts.PublicURL != "" {
+		return s.opts.PublicURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// escapePath percent-encodes each segment of a slash-separated path.
+func escapePath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(awsEscapePath(p), "%40", "@")
+	}
+	return strings.Join(parts, "/")
+}
+
+// isWithin reports whether target is inside root.
+func isWithin(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isHidden reports whether any segment of a slash-separated path starts with a dot.
+func isHidden(rel string) bool {
+	for _, p := range strings.Split(rel, "/") {
+		if strings.HasPrefix(p, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenAndServe runs a registry server until it fails.
+func ListenAndServe(addr string, opts ServerOptions) error {
+	s, err := NewServer(opts)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           logRequests(s),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// statusRecorder captures the response status for access logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		h.ServeHTTP(rec, r)
+		rangeInfo := ""
+		if rg := r.Header.Get("Range"); rg != "" {
+			rangeInfo = " " + rg
+		}
+		fmt.Printf("%s %s %s%s -> %d (%s)\n", time.Now().Format("15:04:05"), r.Method, r.URL.Path, rangeInfo,
+			rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
@@ -0,0 +1,140 @@
+/*
+Package registry provides tooling for registry maintainers.
+server_client.go publishes to a 'swiftstack registry serve' instance through
+its publish API. The server verifies the upload and updates its own manifest,
+so the client needs no write access to the storage behind it.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+type serverClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newServerClient(cfg config.PublishConfig) *serverClient {
+	return &serverClient{
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		token:   os.Getenv("SWIFTSTACK_PUBLISH_TOKEN"),
+		client:  utils.HTTPClient,
+	}
+}
+
+func (c *serverClient) Publish(ctx context.Context, req PublishRequest) (*models.SliceMetadata, error) {
+	s := req.Slice
+	if s.ID == "" || s.Version == "" {
+		return nil, fmt.Errorf("registry: id and version are required")
+	}
+	if c.token == "" {
+		return nil, fmt.Errorf("registry: the server backend needs SWIFTSTACK_PUBLISH_TOKEN")
+	}
+
+	hash, err := utils.HashFile(req.SlicePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(req.SlicePath)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+
+	q := url.Values{}
+	if req.Kind != "" {
+		q.Set("kind", req.Kind)
+	}
+	if s.Title != "" {
+		q.Set("title", s.Title)
+	}
+	if s.Description != "" {
+		q.Set("description", s.Description)
+	}
+	if len(s.Dependencies) > 0 {
+		q.Set("deps", strings.Join(s.Dependencies, ","))
+	}
+	target := c.baseURL + APIPrefix + url.PathEscape(s.ID) + "/" + url.PathEscape(s.Version)
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, target, f)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	httpReq.ContentLength = info.Size()
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	httpReq.Header.Set(HeaderSliceHash, hash)
+	if req.SignaturePath != "" {
+		sig, err := os.ReadFile(req.SignaturePath)
+		if err != nil {
+			return nil, fmt.Errorf("registry: failed to read signature: %w", err)
+		}
+		httpReq.Header.Set(HeaderSliceSignature, strings.TrimSpace(string(sig)))
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("registry: PUT %s failed: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("registry: failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, serverError(resp.StatusCode, body, s)
+	}
+
+	var entry models.SliceMetadata
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return nil, fmt.Errorf("registry: invalid response from server: %w", err)
+	}
+	if entry.Hash != hash {
+		return nil, fmt.Errorf("registry: server recorded hash %s, expected %s", entry.Hash, hash)
+	}
+	if req.ManifestKey != nil {
+		fmt.Println("Note: --manifest-key is ignored; the server signs its own manifest")
+	}
+	return &entry, nil
+}
+
+// serverError turns a failed API response into an error, keeping the
+// server's message and mapping 409 to ErrVersionExists.
+func serverError(status int, body []byte, s models.SliceMetadata) error {
+	var apiErr apiError
+	msg := http.StatusText(status)
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		msg = apiErr.Error
+	}
+
+	switch status {
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %s@%s", ErrVersionExists, s.ID, s.Version)
+	case http.StatusUnauthorized:
+		return errors.New("registry: the server rejected SWIFTSTACK_PUBLISH_TOKEN")
+	default:
+		return fmt.Errorf("registry: server returned %d: %s", status, msg)
+	}
+}
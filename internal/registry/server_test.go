@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+)
+
+// newTestRegistry serves a directory with one base and one addon.
+func newTestRegistry(t *testing.T) (*httptest.Server, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+
+	base := make([]byte, 256*1024)
+	rand.Read(base)
+	if err := os.MkdirAll(filepath.Join(dir, "bases"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bases", "next-base@1.0.0.tar.zst"), base, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tailwind@2.1.0.tar.zst"), []byte("addon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tailwind@2.1.0.json"), []byte(`{"title":"Tailwind","dependencies":["next-base"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(ServerOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts, base
+}
+
+func TestServerManifest(t *testing.T) {
+	ts, _ := newTestRegistry(t)
+
+	resp, err := http.Get(ts.URL + ManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var m models.RemoteManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Bases) != 1 || len(m.Addons) != 1 {
+		t.Fatalf("got %d bases, %d addons; want 1 and 1", len(m.Bases), len(m.Addons))
+	}
+	if b := m.Bases[0]; b.ID != "next-base" || b.Version != "1.0.0" || b.URL != ts.URL+"/bases/next-base@1.0.0.tar.zst" {
+		t.Errorf("unexpected base %+v", b)
+	}
+	if a := m.Addons[0]; a.Title != "Tailwind" || len(a.Dependencies) != 1 || a.Size != 5 {
+		t.Errorf("sidecar metadata not applied: %+v", a)
+	}
+	if issues := Validate(&m); len(issues) != 0 {
+		t.Errorf("generated manifest is invalid: %v", issues)
+	}
+
+	// Conditional GET
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+ManifestPath, nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional GET status = %d; want 304", resp2.StatusCode)
+	}
+}
+
+func TestServerRangeAndHead(t *testing.T) {
+	ts, base := newTestRegistry(t)
+	url := ts.URL + "/bases/next-base@1.0.0.tar.zst"
+
+	head, err := http.Head(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.Body.Close()
+	if head.ContentLength != int64(len(base)) || head.Header.Get("Accept-Ranges") != "bytes" || head.Header.Get("ETag") == "" {
+		t.Fatalf("HEAD: length %d, headers %v", head.ContentLength, head.Header)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Range", "bytes=100-199")
+	req.Header.Set("If-Range", head.Header.Get("ETag"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != string(base[100:200]) {
+		t.Errorf("range request: status %d, %d bytes", resp.StatusCode, len(body))
+	}
+
+	for _, p := range []string{"/../secret.tar.zst", "/registry.go", "/.hidden@1.0.0.tar.zst"} {
+		resp, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s = %d; want 404", p, resp.StatusCode)
+		}
+	}
+}
+
+// TestServerConcurrentDownload exercises the real download path end to end.
+func TestServerConcurrentDownload(t *testing.T) {
+	ts, base := newTestRegistry(t)
+
+	dest := filepath.Join(t.TempDir(), "next-base.tar.zst")
+	if err := utils.DownloadFileConcurrent(ts.URL+"/bases/next-base@1.0.0.tar.zst", dest, 4); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(base) {
+		t.Errorf("downloaded %d bytes that differ from the served slice", len(got))
+	}
+}
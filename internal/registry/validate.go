@@ -0,0 +1,306 @@
+/*
+Package registry provides tooling for registry maintainers: validating,
+publishing and serving SwiftStack manifests.
+validate.go checks a manifest for mistakes that would break consumers.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/gitsrc"
+	"github.com/004Ongoro/swiftstack/internal/models"
+	"github.com/004Ongoro/swiftstack/internal/utils"
+	"github.com/blang/semver/v4"
+)
+
+// Issue is a single problem found in a manifest.
+type Issue struct {
+	Slice   string // id@version, or empty for manifest-wide issues
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Slice == "" {
+		return i.Message
+	}
+	return fmt.Sprintf("%s: %s", i.Slice, i.Message)
+}
+
+// LoadFile reads and parses a manifest file such as registry.json.
+func LoadFile(path string) (*models.RemoteManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("registry: failed to read %s: %w", path, err)
+	}
+
+	var m models.RemoteManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("registry: failed to parse %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Validate checks the static properties of a manifest: unique ids,
+// well-formed hashes, versions and URLs, and resolvable dependencies,
+// compatible bases and deprecation replacements.
+func Validate(m *models.RemoteManifest) []Issue {
+	var issues []Issue
+
+	kinds := make(map[string]string) // id -> "base" or "addon"
+	versions := make(map[string]bool)
+
+	check := func(kind string, list []models.SliceMetadata) {
+		for i, s := range list {
+			ref := sliceRef(s)
+			if s.ID == "" {
+				issues = append(issues, Issue{Message: fmt.Sprintf("%s #%d has no id", kind, i+1)})
+				continue
+			}
+
+			if other, ok := kinds[s.ID]; ok && other != kind {
+				issues = append(issues, Issue{ref, "id is used by both a base and an addon"})
+			}
+			kinds[s.ID] = kind
+
+			if versions[ref] {
+				issues = append(issues, Issue{ref, "duplicate id and version"})
+			}
+			versions[ref] = true
+
+			issues = append(issues, checkEntry(s)...)
+		}
+	}
+	check("base", m.Bases)
+	check("addon", m.Addons)
+
+	// Dependencies must point at ids declared somewhere in the manifest
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			for _, dep := range s.Dependencies {
+				if dep == s.ID {
+					issues = append(issues, Issue{sliceRef(s), "depends on itself"})
+				} else if _, ok := kinds[dep]; !ok {
+					issues = append(issues, Issue{sliceRef(s), fmt.Sprintf("dependency '%s' is not in the manifest", dep)})
+				}
+			}
+			issues = append(issues, checkCompatibleBases(s, kinds)...)
+			// A suggested replacement must be something consumers can use
+			if s.Deprecated != nil && s.Deprecated.Replacement != "" {
+				r := s.Deprecated.Replacement
+				id, _, pinned := strings.Cut(r, "@")
+				if _, ok := kinds[id]; !ok || (pinned && !versions[r]) {
+					issues = append(issues, Issue{sliceRef(s), fmt.Sprintf("deprecation replacement '%s' is not in the manifest", r)})
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// checkCompatibleBases checks that compatibleBases is only set on addons,
+// names bases of the manifest and uses valid version constraints.
+func checkCompatibleBases(s models.SliceMetadata, kinds map[string]string) []Issue {
+	if len(s.CompatibleBases) == 0 {
+		return nil
+	}
+	if kinds[s.ID] == "base" {
+		return []Issue{{sliceRef(s), "compatibleBases is only meaningful for addons"}}
+	}
+
+	ids := make([]string, 0, len(s.CompatibleBases))
+	for id := range s.CompatibleBases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var issues []Issue
+	for _, id := range ids {
+		if kinds[id] != "base" {
+			issues = append(issues, Issue{sliceRef(s), fmt.Sprintf("compatible base '%s' is not a base in the manifest", id)})
+		}
+		if _, err := cache.ParseConstraint(s.CompatibleBases[id]); err != nil {
+			issues = append(issues, Issue{sliceRef(s), fmt.Sprintf("compatible base '%s': %v", id, err)})
+		}
+	}
+	return issues
+}
+
+// checkEntry validates the fields of a single slice.
+func checkEntry(s models.SliceMetadata) []Issue {
+	var issues []Issue
+	ref := sliceRef(s)
+
+	if err := checkEntryHash(s); err != nil {
+		issues = append(issues, Issue{ref, err.Error()})
+	}
+
+	if s.Version == "" {
+		issues = append(issues, Issue{ref, "version is missing"})
+	} else if _, err := semver.Parse(s.Version); err != nil {
+		issues = append(issues, Issue{ref, fmt.Sprintf("version '%s' is not valid semver: %v", s.Version, err)})
+	}
+
+	if err := checkURL(s.URL); err != nil {
+		issues = append(issues, Issue{ref, err.Error()})
+	}
+
+	return issues
+}
+
+// checkEntryHash checks the hash field: a SHA-256 for archives, or for git
+// sources an optional commit id the ref must resolve to.
+func checkEntryHash(s models.SliceMetadata) error {
+	if !gitsrc.IsSource(s.URL) {
+		return checkHash(s.Hash)
+	}
+	if s.Hash != "" && !gitsrc.IsCommit(s.Hash) {
+		return fmt.Errorf("hash of a git source must be a full lowercase commit id")
+	}
+	return nil
+}
+
+func checkHash(hash string) error {
+	if hash == "" {
+		return fmt.Errorf("hash is missing; consumers cannot verify the slice")
+	}
+	if len(hash) != 64 {
+		return fmt.Errorf("hash must be 64 hex characters, got %d", len(hash))
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return fmt.Errorf("hash is not hexadecimal")
+	}
+	if hash != strings.ToLower(hash) {
+		return fmt.Errorf("hash must be lowercase hex")
+	}
+	return nil
+}
+
+// checkURL accepts any location a consumer can fetch: http(s) URLs, file://
+// URLs, paths relative to the manifest and schemes with a registered fetcher.
+func checkURL(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("url is missing")
+	}
+	if isWindowsPath(raw) {
+		return fmt.Errorf("url '%s' is a Windows path; use a path relative to the manifest or a file:// URL", raw)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("url '%s' is malformed: %v", raw, err)
+	}
+	switch {
+	case u.Scheme == "":
+		return nil // a path, resolved against the manifest's location
+	case u.Scheme == "http" || u.Scheme == "https":
+		if u.Host == "" {
+			return fmt.Errorf("url '%s' has no host", raw)
+		}
+	case gitsrc.IsSource(raw):
+		if _, err := gitsrc.Parse(raw); err != nil {
+			return err
+		}
+	case u.Scheme == "file":
+		if u.Path == "" && u.Opaque == "" {
+			return fmt.Errorf("url '%s' has no path", raw)
+		}
+	case !utils.HasFetcher(u.Scheme):
+		return fmt.Errorf("url '%s' uses an unsupported scheme (want one of %s)",
+			raw, strings.Join(utils.FetcherSchemes(), ", "))
+	}
+	return nil
+}
+
+// VerifyDownloads downloads every slice into a temporary directory and
+// confirms its SHA-256 matches the manifest. Relative URLs are resolved
+// against location, where the manifest lives. Git sources are packed and
+// their ref checked against the pinned commit instead.
+func VerifyDownloads(m *models.RemoteManifest, location string, chunks int, progress func(ref string)) []Issue {
+	var issues []Issue
+
+	tmpDir, err := os.MkdirTemp("", "swiftstack-validate-*")
+	if err != nil {
+		return []Issue{{Message: fmt.Sprintf("failed to create temp dir: %v", err)}}
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, list := range [][]models.SliceMetadata{m.Bases, m.Addons} {
+		for _, s := range list {
+			ref := sliceRef(s)
+			if checkURL(s.URL) != nil || checkEntryHash(s) != nil {
+				continue // already reported by Validate
+			}
+			if progress != nil {
+				progress(ref)
+			}
+			if gitsrc.IsSource(s.URL) {
+				if err := verifyGitSource(s, tmpDir); err != nil {
+					issues = append(issues, Issue{ref, err.Error()})
+				}
+				continue
+			}
+
+			src, err := utils.ResolveURL(s.URL, location)
+			if err != nil {
+				issues = append(issues, Issue{ref, err.Error()})
+				continue
+			}
+			dest := filepath.Join(tmpDir, strings.ReplaceAll(ref, "/", "_")+".tar.zst")
+			if err := utils.Download(src.String(), dest, chunks); err != nil {
+				issues = append(issues, Issue{ref, fmt.Sprintf("download failed: %v", err)})
+				continue
+			}
+			if err := utils.VerifyFileHash(dest, s.Hash); err != nil {
+				issues = append(issues, Issue{ref, err.Error()})
+			}
+			os.Remove(dest)
+		}
+	}
+	return issues
+}
+
+// verifyGitSource resolves and packs a git source, checking the pinned commit.
+func verifyGitSource(s models.SliceMetadata, tmpDir string) error {
+	src, err := gitsrc.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	commit, err := gitsrc.Resolve(ctx, src)
+	if err != nil {
+		return err
+	}
+	if s.Hash != "" && s.Hash != commit {
+		return fmt.Errorf("%s resolves to commit %s, but the hash pins %s", src.Ref, commit, s.Hash)
+	}
+	dest := filepath.Join(tmpDir, "git-"+commit+".tar.zst")
+	defer os.Remove(dest)
+	return gitsrc.Pack(ctx, src, commit, dest)
+}
+
+// isWindowsPath reports paths like C:\slices\a.tar.zst or \\server\share.
+func isWindowsPath(raw string) bool {
+	if strings.Contains(raw, `\`) {
+		return true
+	}
+	return len(raw) >= 2 && raw[1] == ':' &&
+		(raw[0] >= 'a' && raw[0] <= 'z' || raw[0] >= 'A' && raw[0] <= 'Z')
+}
+
+func sliceRef(s models.SliceMetadata) string {
+	if s.Version == "" {
+		return s.ID
+	}
+	return s.ID + "@" + s.Version
+}
@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
+)
+
+const goodHash = "9f2c7d4b6a5e2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c"
+
+func slice(id, version, url, hash string, deps ...string) models.SliceMetadata {
+	return models.SliceMetadata{ID: id, Version: version, URL: url, Hash: hash, Dependencies: deps}
+}
+
+func deprecated(s models.SliceMetadata, replacement string) models.SliceMetadata {
+	s.Deprecated = &models.Deprecation{Message: "superseded", Replacement: replacement}
+	return s
+}
+
+func compatible(s models.SliceMetadata, base, constraint string) models.SliceMetadata {
+	s.CompatibleBases = map[string]string{base: constraint}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest models.RemoteManifest
+		want     string // substring of the single expected issue, empty for none
+	}{
+		{
+			name: "valid",
+			manifest: models.RemoteManifest{
+				Bases:  []models.SliceMetadata{slice("next-base", "1.0.0", "https://cdn.example.com/next.tar.zst", goodHash)},
+				Addons: []models.SliceMetadata{slice("tailwind", "1.0.0", "https://cdn.example.com/tw.tar.zst", goodHash, "next-base")},
+			},
+		},
+		{
+			name: "id in bases and addons",
+			manifest: models.RemoteManifest{
+				Bases:  []models.SliceMetadata{slice("ui", "1.0.0", "https://a.example/ui.tar.zst", goodHash)},
+				Addons: []models.SliceMetadata{slice("ui", "2.0.0", "https://a.example/ui2.tar.zst", goodHash)},
+			},
+			want: "both a base and an addon",
+		},
+		{
+			name: "duplicate version",
+			manifest: models.RemoteManifest{
+				Addons: []models.SliceMetadata{
+					slice("ui", "1.0.0", "https://a.example/ui.tar.zst", goodHash),
+					slice("ui", "1.0.0", "https://a.example/ui.tar.zst", goodHash),
+				},
+			},
+			want: "duplicate id and version",
+		},
+		{
+			name:     "missing hash",
+			manifest: models.RemoteManifest{Bases: []models.SliceMetadata{slice("b", "1.0.0", "https://a.example/b.tar.zst", "")}},
+			want:     "hash is missing",
+		},
+		{
+			name:     "uppercase hash",
+			manifest: models.RemoteManifest{Bases: []models.SliceMetadata{slice("b", "1.0.0", "https://a.example/b.tar.zst", strings.ToUpper(goodHash))}},
+			want:     "lowercase",
+		},
+		{
+			name:     "non-semver version",
+			manifest: models.RemoteManifest{Bases: []models.SliceMetadata{slice("b", "1.0", "https://a.example/b.tar.zst", goodHash)}},
+			want:     "not valid semver",
+		},
+		{
+			name:     "windows path",
+			manifest: models.RemoteManifest{Bases: []models.SliceMetadata{slice("b", "1.0.0", `C:\Users\zeon\base.tar.zst`, goodHash)}},
+			want:     "Windows path",
+		},
+		{
+			name: "local locations",
+			manifest: models.RemoteManifest{Bases: []models.SliceMetadata{
+				slice("a", "1.0.0", "slices/a.tar.zst", goodHash),
+				slice("b", "1.0.0", "file:///srv/slices/b.tar.zst", goodHash),
+			}},
+		},
+		{
+			name: "git sources",
+			manifest: models.RemoteManifest{Addons: []models.SliceMetadata{
+				slice("a", "1.0.0", "git+file:///srv/addons.git#v1.0.0:a", ""),
+				slice("b", "1.0.0", "git+https://git.example.com/addons.git#main:b", strings.Repeat("ab", 20)),
+			}},
+		},
+		{
+			name:     "git source with a malformed commit",
+			manifest: models.RemoteManifest{Addons: []models.SliceMetadata{slice("a", "1.0.0", "git+file:///srv/addons.git#v1.0.0:a", goodHash[:40]+"X")}},
+			want:     "commit id",
+		},
+		{
+			name:     "unsupported scheme",
+			manifest: models.RemoteManifest{Bases: []models.SliceMetadata{slice("b", "1.0.0", "ftp://a.example/b.tar.zst", goodHash)}},
+			want:     "unsupported scheme",
+		},
+		{
+			name:     "unknown dependency",
+			manifest: models.RemoteManifest{Addons: []models.SliceMetadata{slice("a", "1.0.0", "https://a.example/a.tar.zst", goodHash, "ghost")}},
+			want:     "dependency 'ghost'",
+		},
+		{
+			name: "compatible bases",
+			manifest: models.RemoteManifest{
+				Bases:  []models.SliceMetadata{slice("next-base", "1.0.0", "https://a.example/n.tar.zst", goodHash)},
+				Addons: []models.SliceMetadata{compatible(slice("auth", "1.0.0", "https://a.example/a.tar.zst", goodHash), "next-base", "^1.0.0")},
+			},
+		},
+		{
+			name: "unknown compatible base",
+			manifest: models.RemoteManifest{
+				Bases:  []models.SliceMetadata{slice("next-base", "1.0.0", "https://a.example/n.tar.zst", goodHash)},
+				Addons: []models.SliceMetadata{compatible(slice("auth", "1.0.0", "https://a.example/a.tar.zst", goodHash), "vite-react", "")},
+			},
+			want: "compatible base 'vite-react'",
+		},
+		{
+			name: "invalid compatibility constraint",
+			manifest: models.RemoteManifest{
+				Bases:  []models.SliceMetadata{slice("next-base", "1.0.0", "https://a.example/n.tar.zst", goodHash)},
+				Addons: []models.SliceMetadata{compatible(slice("auth", "1.0.0", "https://a.example/a.tar.zst", goodHash), "next-base", ">=one")},
+			},
+			want: "invalid version constraint",
+		},
+		{
+			name: "deprecation replacement",
+			manifest: models.RemoteManifest{Addons: []models.SliceMetadata{
+				deprecated(slice("auth", "1.0.0", "https://a.example/a1.tar.zst", goodHash), "auth@2.0.0"),
+				slice("auth", "2.0.0", "https://a.example/a2.tar.zst", goodHash),
+			}},
+		},
+		{
+			name:     "unknown deprecation replacement",
+			manifest: models.RemoteManifest{Addons: []models.SliceMetadata{deprecated(slice("auth", "1.0.0", "https://a.example/a1.tar.zst", goodHash), "auth@2.0.0")}},
+			want:     "replacement 'auth@2.0.0'",
+		},
+	}
+
+	for _, tt := range tests {
+		issues := Validate(&tt.manifest)
+		if tt.want == "" {
+			if len(issues) != 0 {
+				t.Errorf("%s: got issues %v; want none", tt.name, issues)
+			}
+			continue
+		}
+		if len(issues) != 1 || !strings.Contains(issues[0].String(), tt.want) {
+			t.Errorf("%s: got issues %v; want one containing %q", tt.name, issues, tt.want)
+		}
+	}
+}
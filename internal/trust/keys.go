@@ -0,0 +1,207 @@
+/*
+Package trust manages the ed25519 keys SwiftStack uses to sign and verify
+registry manifests. Trusted public keys are kept in a keyring file in the
+user config directory; private keys live wherever their owner stores them.
+*/
+package trust
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+)
+
+// TrustedKey is a named public key allowed to sign manifests.
+type TrustedKey struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"publicKey"` // base64-encoded raw ed25519 public key
+}
+
+// Keyring is the set of trusted public keys.
+type Keyring struct {
+	Keys []TrustedKey `json:"keys"`
+}
+
+// KeyringPath returns the location of the trusted keys file.
+func KeyringPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trusted_keys.json"), nil
+}
+
+// LoadKeyring reads the trusted keys. A missing keyring is empty, not an error.
+func LoadKeyring() (*Keyring, error) {
+	path, err := KeyringPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Keyring{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trust: failed to read keyring: %w", err)
+	}
+
+	var k Keyring
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("trust: failed to parse keyring %s: %w", path, err)
+	}
+	return &k, nil
+}
+
+// Save writes the keyring back to disk.
+func (k *Keyring) Save() error {
+	path, err := KeyringPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("trust: failed to create config dir: %w", err)
+	}
+
+	sort.Slice(k.Keys, func(i, j int) bool { return k.Keys[i].Name < k.Keys[j].Name })
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return fmt.Errorf("trust: failed to marshal keyring: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Add trusts a public key under name. Names must be unique.
+func (k *Keyring) Add(name string, pub ed25519.PublicKey) error {
+	if name == "" {
+		return fmt.Errorf("trust: key name is required")
+	}
+	for _, existing := range k.Keys {
+		if existing.Name == name {
+			return fmt.Errorf("trust: a key named '%s' already exists", name)
+		}
+	}
+	k.Keys = append(k.Keys, TrustedKey{Name: name, PublicKey: EncodePublicKey(pub)})
+	return nil
+}
+
+// Remove stops trusting the key with the given name.
+func (k *Keyring) Remove(name string) error {
+	for i, existing := range k.Keys {
+		if existing.Name == name {
+			k.Keys = append(k.Keys[:i], k.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("trust: no key named '%s'", name)
+}
+
+// Find returns the trusted key with the given name.
+func (k *Keyring) Find(name string) (TrustedKey, bool) {
+	for _, existing := range k.Keys {
+		if existing.Name == name {
+			return existing, true
+		}
+	}
+	return TrustedKey{}, false
+}
+
+// Fingerprint returns a short, stable identifier for a public key.
+func (t TrustedKey) Fingerprint() string {
+	pub, err := ParsePublicKey(t.PublicKey)
+	if err != nil {
+		return "invalid"
+	}
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// EncodePublicKey returns the textual form used on the command line and in the keyring.
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey accepts a base64 raw key or a PEM "PUBLIC KEY" block.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("trust: invalid PEM public key: %w", err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("trust: PEM public key is not ed25519")
+		}
+		return pub, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("trust: public key is not valid base64: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("trust: public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// GenerateKey creates a new signing key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("trust: failed to generate key: %w", err)
+	}
+	return pub, priv, nil
+}
+
+// WritePrivateKey stores a private key as a PKCS#8 PEM file readable only by its owner.
+func WritePrivateKey(path string, priv ed25519.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("trust: failed to encode private key: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("trust: failed to create key file: %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// ReadPrivateKey loads a PKCS#8 PEM ed25519 private key.
+func ReadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("trust: failed to read private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("trust: %s is not a PEM file", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("trust: invalid private key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("trust: private key is not ed25519")
+	}
+	return priv, nil
+}
@@ -0,0 +1,156 @@
+/*
+Package trust manages the ed25519 keys SwiftStack uses to sign and verify
+registry manifests and slices.
+signature.go handles detached signatures and the verification policies.
+*/
+package trust
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SignatureExt is appended to a file's name (or URL) to locate its detached signature.
+const SignatureExt = ".sig"
+
+// ErrUnsigned is returned when a signature is required but none was provided.
+var ErrUnsigned = errors.New("trust: manifest is not signed")
+
+// ErrSliceUnsigned is returned when a registry requires slice signatures and none exists.
+var ErrSliceUnsigned = errors.New("trust: slice is not signed")
+
+// Sign returns the detached signature file content for data.
+func Sign(priv ed25519.PrivateKey, data []byte) []byte {
+	sig := ed25519.Sign(priv, data)
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// SignFile writes path+".sig" containing a signature of the file at path.
+func SignFile(priv ed25519.PrivateKey, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("trust: failed to read %s: %w", path, err)
+	}
+	sigPath := path + SignatureExt
+	if err := os.WriteFile(sigPath, Sign(priv, data), 0644); err != nil {
+		return "", fmt.Errorf("trust: failed to write signature: %w", err)
+	}
+	return sigPath, nil
+}
+
+// Slices are signed by digest: the signed message is sliceMessagePrefix
+// followed by the archive's SHA-256 in hex, so multi-GB archives are
+// streamed rather than loaded into memory.
+const sliceMessagePrefix = "swiftstack-slice-sha256:"
+
+// SignSlice writes path+".sig" containing a signature of the SHA-256
+// digest of the slice archive at path.
+func SignSlice(priv ed25519.PrivateKey, path string) (string, error) {
+	hash, err := fileHash(path)
+	if err != nil {
+		return "", err
+	}
+	sigPath := path + SignatureExt
+	if err := os.WriteFile(sigPath, Sign(priv, sliceMessage(hash)), 0644); err != nil {
+		return "", fmt.Errorf("trust: failed to write signature: %w", err)
+	}
+	return sigPath, nil
+}
+
+func sliceMessage(hash string) []byte {
+	return []byte(sliceMessagePrefix + strings.ToLower(hash))
+}
+
+// fileHash streams the file at path through SHA-256.
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("trust: failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("trust: failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks a detached signature against each key and returns the name
+// of the first key that produced it.
+func Verify(data, sig []byte, keys []TrustedKey) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return "", fmt.Errorf("trust: malformed signature")
+	}
+
+	for _, k := range keys {
+		pub, err := ParsePublicKey(k.PublicKey)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, data, raw) {
+			return k.Name, nil
+		}
+	}
+	return "", fmt.Errorf("trust: signature does not match any trusted key")
+}
+
+// VerifyManifest applies the manifest policy: once at least one key is
+// trusted, every manifest must carry a valid signature from one of them.
+// With an empty keyring, manifests are accepted unsigned.
+func VerifyManifest(data, sig []byte) error {
+	k, err := LoadKeyring()
+	if err != nil {
+		return err
+	}
+	if len(k.Keys) == 0 {
+		return nil
+	}
+	if len(sig) == 0 {
+		return ErrUnsigned
+	}
+	_, err = Verify(data, sig, k.Keys)
+	return err
+}
+
+// VerifySlice checks the slice archive at path, whose SHA-256 is hash
+// (computed from the file when empty), against its detached signature,
+// accepting only the keyring entries named in signers (or any trusted key
+// when signers is empty). It returns the name of the signing key.
+func VerifySlice(path, hash string, sig []byte, signers []string) (string, error) {
+	if len(sig) == 0 {
+		return "", ErrSliceUnsigned
+	}
+
+	ring, err := LoadKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	keys := ring.Keys
+	if len(signers) > 0 {
+		keys = nil
+		for _, name := range signers {
+			if k, ok := ring.Find(name); ok {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("trust: none of the allowed signers are in the keyring")
+	}
+
+	if hash == "" {
+		if hash, err = fileHash(path); err != nil {
+			return "", err
+		}
+	}
+	return Verify(sliceMessage(hash), sig, keys)
+}
@@ -0,0 +1,110 @@
+package trust
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifyManifestPolicy(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	manifest := []byte(`{"bases":[],"addons":[]}`)
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := Sign(priv, manifest)
+
+	// Empty keyring: unsigned manifests are accepted
+	if err := VerifyManifest(manifest, nil); err != nil {
+		t.Fatalf("empty keyring rejected unsigned manifest: %v", err)
+	}
+
+	ring, _ := LoadKeyring()
+	if err := ring.Add("acme", pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		manifest []byte
+		sig      []byte
+		ok       bool
+	}{
+		{"valid signature", manifest, sig, true},
+		{"unsigned", manifest, nil, false},
+		{"tampered manifest", []byte(`{"bases":[{"id":"evil"}]}`), sig, false},
+		{"garbage signature", manifest, []byte("not-a-signature"), false},
+	}
+
+	for _, tt := range tests {
+		err := VerifyManifest(tt.manifest, tt.sig)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: VerifyManifest() error = %v; want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+
+	if err := VerifyManifest(manifest, nil); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned manifest error = %v; want ErrUnsigned", err)
+	}
+}
+
+func TestParsePublicKeyRejectsWrongSize(t *testing.T) {
+	if _, err := ParsePublicKey("c2hvcnQ="); err == nil {
+		t.Error("ParsePublicKey accepted a 5-byte key")
+	}
+}
+
+func TestVerifySliceSigners(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	slice := t.TempDir() + "/next-base@1.0.0.tar.zst"
+	if err := os.WriteFile(slice, []byte("slice-bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	alicePub, alicePriv, _ := GenerateKey()
+	bobPub, _, _ := GenerateKey()
+	ring, _ := LoadKeyring()
+	ring.Add("alice", alicePub)
+	ring.Add("bob", bobPub)
+	if err := ring.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	sigPath, err := SignSlice(alicePriv, slice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := os.ReadFile(sigPath)
+	other := Sign(alicePriv, sliceMessage(strings.Repeat("0", 64)))
+
+	tests := []struct {
+		name    string
+		sig     []byte
+		signers []string
+		ok      bool
+	}{
+		{"any trusted key", sig, nil, true},
+		{"allowed signer", sig, []string{"alice"}, true},
+		{"signer not allowed", sig, []string{"bob"}, false},
+		{"unknown signer only", sig, []string{"mallory"}, false},
+		{"missing signature", nil, nil, false},
+		{"signature of another digest", other, nil, false},
+	}
+
+	for _, tt := range tests {
+		signer, err := VerifySlice(slice, "", tt.sig, tt.signers)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: VerifySlice() error = %v; want ok=%v", tt.name, err, tt.ok)
+		}
+		if tt.ok && signer != "alice" {
+			t.Errorf("%s: signer = %s; want alice", tt.name, signer)
+		}
+	}
+}
@@ -5,11 +5,16 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/004Ongoro/swiftstack/internal/cache"
+	"github.com/004Ongoro/swiftstack/internal/config"
 	"github.com/004Ongoro/swiftstack/internal/engine"
+	"github.com/004Ongoro/swiftstack/internal/utils"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -23,11 +28,13 @@ var (
 
 type item struct {
 	id, title, desc string
+	version         string // newest usable version
+	keywords        string // category and tags, matched by the list filter
 }
 
 func (i item) Title() string       { return i.title }
 func (i item) Description() string { return i.desc }
-func (i item) FilterValue() string { return i.title }
+func (i item) FilterValue() string { return i.title + " " + i.keywords }
 
 type Step int
 
@@ -46,11 +53,21 @@ type WizardModel struct {
 	baseList       list.Model
 	addonList      list.Model
 	selectedBase   string
-	selectedAddons map[int]struct{} // Tracks indexes of checked addons
+	addons         []cache.SliceInfo // every addon; the list shows those that fit the base
+	selectedAddons map[int]struct{}  // Tracks indexes of checked addons
 	err            error
 	status         string
+	downloads      chan utils.Progress // fed by the download hook while generating
+	download       *utils.Progress     // latest snapshot of the running download
+	bar            progress.Model
 }
 
+// progressMsg carries a download snapshot into Update.
+type progressMsg utils.Progress
+
+// generatedMsg reports that the project was assembled.
+type generatedMsg struct{}
+
 func InitialModel() WizardModel {
 	// 1. Load the dynamic manifest
 	manifest, _ := cache.LoadManifest()
@@ -60,20 +77,23 @@ func InitialModel() WizardModel {
 	ti.Placeholder = "my-awesome-app"
 	ti.Focus()
 
-	// 3. Initialize Base Selection List from manifest
-	baseItems := make([]list.Item, len(manifest.Bases))
-	for i, b := range manifest.Bases {
-		baseItems[i] = item{id: b.ID, title: b.Title, desc: b.Description}
+	// 3. Build one item per base; addons are listed once a base is chosen
+	var baseItems []list.Item
+	var addons []cache.SliceInfo
+	if manifest != nil {
+		for _, s := range cache.Catalog(manifest) {
+			if s.Kind != "base" {
+				addons = append(addons, s)
+			} else if it, ok := sliceItem(s); ok {
+				baseItems = append(baseItems, it)
+			}
+		}
 	}
 	bl := list.New(baseItems, list.NewDefaultDelegate(), 0, 0)
 	bl.Title = "Select Base Template"
 
-	// 4. Initialize Addon Selection List from manifest
-	addonItems := make([]list.Item, len(manifest.Addons))
-	for i, a := range manifest.Addons {
-		addonItems[i] = item{id: a.ID, title: a.Title, desc: a.Description}
-	}
-	al := list.New(addonItems, list.NewDefaultDelegate(), 0, 0)
+	// 4. Initialize Addon Selection List
+	al := list.New(nil, list.NewDefaultDelegate(), 0, 0)
 	al.Title = "Select Addons (Space to toggle)"
 
 	return WizardModel{
@@ -81,7 +101,9 @@ func InitialModel() WizardModel {
 		projectName:    ti,
 		baseList:       bl,
 		addonList:      al,
+		addons:         addons,
 		selectedAddons: make(map[int]struct{}),
+		bar:            progress.New(progress.WithDefaultGradient(), progress.WithWidth(40)),
 	}
 }
 
@@ -124,6 +146,9 @@ func (m WizardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				i, ok := m.baseList.SelectedItem().(item)
 				if ok {
 					m.selectedBase = i.id
+					m.addonList.SetItems(addonItems(m.addons, i))
+					m.addonList.Title = fmt.Sprintf("Select Addons for %s (Space to toggle)", i.title)
+					m.selectedAddons = make(map[int]struct{})
 					m.step = StepAddons
 				}
 			case StepAddons:
@@ -131,12 +156,22 @@ func (m WizardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case StepConfirm:
 				m.step = StepProcessing
 				m.status = "Assembling project..."
-				return m, executeGeneration(m)
+				m.downloads = make(chan utils.Progress, 16)
+				return m, tea.Batch(executeGeneration(m), waitForProgress(m.downloads))
 			case StepDone:
 				return m, tea.Quit
 			}
 		}
 
+	case progressMsg:
+		p := utils.Progress(msg)
+		m.download = &p
+		return m, waitForProgress(m.downloads)
+
+	case generatedMsg:
+		m.step = StepDone
+		return m, nil
+
 	case error:
 		m.err = msg
 		m.step = StepDone
@@ -167,13 +202,17 @@ func (m WizardModel) View() string {
 		return docStyle.Render(view + "\n [Space] Toggle | [Enter] Continue")
 	case StepConfirm:
 		addons := []string{}
-		for idx := range m.selectedAddons {
-			addons = append(addons, cache.GetAvailableAddons()[idx].Title)
+		for _, it := range m.chosenAddons() {
+			addons = append(addons, it.title)
 		}
 		return fmt.Sprintf("\n%s\n\nProject: %s\nBase: %s\nAddons: %s\n\n(Enter to Start)",
 			titleStyle.Render("Final Check"), m.projectName.Value(), m.selectedBase, strings.Join(addons, ", "))
 	case StepProcessing:
-		return fmt.Sprintf("\n⏳ %s", m.status)
+		view := fmt.Sprintf("\n⏳ %s", m.status)
+		if p := m.download; p != nil && !p.Finished {
+			view += "\n\n" + downloadView(*p, m.bar)
+		}
+		return view
 	case StepDone:
 		if m.err != nil {
 			return fmt.Sprintf("\n❌ Error: %v", m.err)
@@ -183,20 +222,128 @@ func (m WizardModel) View() string {
 	return ""
 }
 
+// sliceItem builds the list item of a slice from its newest usable version.
+// Slices whose every version was yanked are not offered.
+func sliceItem(s cache.SliceInfo) (item, bool) {
+	latest := s.Latest()
+	if latest.Yanked != nil {
+		return item{}, false
+	}
+	desc := s.Description
+	if latest.Deprecated != nil {
+		desc = "⚠ deprecated"
+		if msg := latest.Deprecated.Message; msg != "" {
+			desc += ": " + msg
+		}
+		if r := latest.Deprecated.Replacement; r != "" {
+			desc += " (use " + r + ")"
+		}
+	}
+	if s.Category != "" {
+		desc = s.Category + " · " + desc
+	}
+	keywords := strings.Join(append([]string{s.Category}, s.Tags...), " ")
+	return item{id: s.ID, title: s.Title, desc: desc, version: latest.Version, keywords: keywords}, true
+}
+
+// addonItems lists the addons compatible with base, grouped by category
+// (uncategorized addons last) and sorted by id within each group.
+func addonItems(addons []cache.SliceInfo, base item) []list.Item {
+	var fit []cache.SliceInfo
+	for _, s := range addons {
+		if cache.CheckCompatible(s.Latest().CompatibleBases, base.id, base.version) == nil {
+			fit = append(fit, s)
+		}
+	}
+	sort.SliceStable(fit, func(i, j int) bool {
+		ci, cj := fit[i].Category, fit[j].Category
+		if (ci == "") != (cj == "") {
+			return cj == ""
+		}
+		if ci != cj {
+			return ci < cj
+		}
+		return fit[i].ID < fit[j].ID
+	})
+
+	var items []list.Item
+	for _, s := range fit {
+		if it, ok := sliceItem(s); ok {
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+// chosenAddons returns the checked addon items in list order.
+func (m WizardModel) chosenAddons() []item {
+	var out []item
+	for idx, li := range m.addonList.Items() {
+		if _, ok := m.selectedAddons[idx]; ok {
+			out = append(out, li.(item))
+		}
+	}
+	return out
+}
+
 func executeGeneration(m WizardModel) tea.Cmd {
 	return func() tea.Msg {
 		addons := []string{}
-		available := cache.GetAvailableAddons()
-		for idx := range m.selectedAddons {
-			addons = append(addons, available[idx].ID)
+		for _, it := range m.chosenAddons() {
+			addons = append(addons, it.id)
 		}
 
 		opts := engine.ProjectOptions{
 			Name:        m.projectName.Value(),
-			OutputPath:  ".",
+			OutputPath:  config.Get().OutputPath,
 			BaseSlice:   m.selectedBase,
 			AddonSlices: addons,
 		}
-		return engine.GenerateProject(opts)
+
+		// Route download progress to the view instead of the terminal
+		utils.SetProgressHook(func(p utils.Progress) {
+			select {
+			case m.downloads <- p:
+			default: // the view is behind; it catches up with a later snapshot
+			}
+		})
+		defer close(m.downloads)
+		defer utils.SetProgressHook(nil)
+
+		if err := engine.GenerateProject(opts); err != nil {
+			return err
+		}
+		return generatedMsg{}
+	}
+}
+
+// waitForProgress delivers the next download snapshot, until the
+// generation closes ch.
+func waitForProgress(ch <-chan utils.Progress) tea.Cmd {
+	return func() tea.Msg {
+		p, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return progressMsg(p)
+	}
+}
+
+// downloadView renders a running download: its URL, a bar when the size is
+// known, and the bytes done, throughput and ETA.
+func downloadView(p utils.Progress, bar progress.Model) string {
+	details := fmt.Sprintf("%s at %s/s", utils.FormatBytes(p.Done), utils.FormatBytes(int64(p.Rate)))
+	if f := p.Fraction(); f >= 0 {
+		eta := "?"
+		if p.ETA >= 0 {
+			eta = p.ETA.Round(time.Second).String()
+		}
+		details = fmt.Sprintf("%s of %s at %s/s, ETA %s", utils.FormatBytes(p.Done),
+			utils.FormatBytes(p.Total), utils.FormatBytes(int64(p.Rate)), eta)
+		details = bar.ViewAs(f) + "\n" + details
+	}
+	if len(p.Chunks) > 1 {
+		details += fmt.Sprintf(" (%d parallel chunks)", len(p.Chunks))
 	}
+	return p.URL + "\n" + details
 }
@@ -0,0 +1,286 @@
+/*
+Package utils provides network and file system helpers.
+auth.go supplies the HTTP client used for every registry and slice request.
+It attaches per-host credentials, looked up (highest precedence first) in
+SWIFTSTACK_TOKEN_<HOST> / SWIFTSTACK_USERNAME_<HOST> + SWIFTSTACK_PASSWORD_<HOST>,
+the auth section of the configuration, and ~/.netrc (or $NETRC).
+Credentials are only ever sent to the host they belong to: never after a
+redirect to another host or from HTTPS to plain HTTP, and never in errors.
+*/
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+)
+
+// HTTPClient is used for all registry and slice downloads.
+var HTTPClient = &http.Client{
+	Transport:     &authTransport{base: http.DefaultTransport},
+	CheckRedirect: checkRedirect,
+}
+
+// Credentials returns the credentials configured for host (host[:port]),
+// or an empty HostAuth when there are none.
+func Credentials(host string) config.HostAuth {
+	host = strings.ToLower(host)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	// A port-specific entry wins over one for the bare host name
+	for _, h := range []string{host, hostname} {
+		if a := envCredentials(h); !a.Empty() {
+			return a
+		}
+	}
+	for _, h := range []string{host, hostname} {
+		if a, ok := config.Get().Auth[h]; ok {
+			return a
+		}
+	}
+	return netrcCredentials(hostname)
+}
+
+// envCredentials reads SWIFTSTACK_TOKEN_<HOST> or SWIFTSTACK_USERNAME_<HOST>
+// and SWIFTSTACK_PASSWORD_<HOST>, where <HOST> is the host upper-cased with
+// every other character replaced by '_' (registry.example.com:8443 becomes
+// REGISTRY_EXAMPLE_COM_8443).
+func envCredentials(host string) config.HostAuth {
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, host)
+	if token := os.Getenv("SWIFTSTACK_TOKEN_" + key); token != "" {
+		return config.HostAuth{Token: token}
+	}
+	return config.HostAuth{
+		Username: os.Getenv("SWIFTSTACK_USERNAME_" + key),
+		Password: os.Getenv("SWIFTSTACK_PASSWORD_" + key),
+	}
+}
+
+// netrcCredentials returns the login and password of the machine entry for
+// host, or of the default entry when host is a configured registry. The
+// default entry is not sent to other hosts, such as third-party servers
+// that slice URLs point at.
+func netrcCredentials(host string) config.HostAuth {
+	n := loadNetrc()
+	if a, ok := n.machines[strings.ToLower(host)]; ok {
+		return a
+	}
+	if isRegistryHost(host) {
+		return n.fallback
+	}
+	return config.HostAuth{}
+}
+
+// isRegistryHost reports whether host (without port) serves one of the
+// configured registry manifests.
+func isRegistryHost(host string) bool {
+	for _, r := range config.Get().Registries {
+		if u, err := url.Parse(r.URL); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// netrc holds the entries of a netrc file.
+type netrc struct {
+	machines map[string]config.HostAuth // by lower-cased host; the first entry wins
+	fallback config.HostAuth            // the default entry
+}
+
+var (
+	netrcMu    sync.Mutex
+	netrcFiles = make(map[string]*netrc) // parsed files by path
+)
+
+// loadNetrc parses $NETRC, or ~/.netrc (_netrc on Windows), once per
+// process. A missing file has no entries.
+func loadNetrc() *netrc {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &netrc{}
+		}
+		name := ".netrc"
+		if runtime.GOOS == "windows" {
+			name = "_netrc"
+		}
+		path = filepath.Join(home, name)
+	}
+
+	netrcMu.Lock()
+	defer netrcMu.Unlock()
+	if n, ok := netrcFiles[path]; ok {
+		return n
+	}
+	n := parseNetrc(path)
+	netrcFiles[path] = n
+	return n
+}
+
+func parseNetrc(path string) *netrc {
+	n := &netrc{machines: make(map[string]config.HostAuth)}
+	f, err := os.Open(path)
+	if err != nil {
+		return n
+	}
+	defer f.Close()
+
+	var current *config.HostAuth // entry being read, nil when skipping
+	var host string              // machine of current, "" for the default entry
+	var inMacro bool
+	flush := func() {
+		if current != nil && host != "" {
+			n.machines[host] = *current
+		}
+		current = nil
+	}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		// A macro definition runs until the next empty line
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			next := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+			switch fields[i] {
+			case "machine":
+				flush()
+				host = strings.ToLower(next())
+				if _, seen := n.machines[host]; !seen {
+					current = &config.HostAuth{}
+				}
+			case "default":
+				flush()
+				host, current = "", &n.fallback
+			case "login":
+				if v := next(); current != nil {
+					current.Username = v
+				}
+			case "password":
+				if v := next(); current != nil {
+					current.Password = v
+				}
+			case "account":
+				next()
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	flush()
+	return n
+}
+
+// authTransport adds the credentials of the request's host, unless the
+// request already carries an Authorization header or is a redirect that
+// left the original host.
+type authTransport struct {
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || !sameOrigin(req) {
+		return t.base.RoundTrip(req)
+	}
+	a := Credentials(req.URL.Host)
+	if a.Empty() {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	} else {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// sameOrigin reports whether a (possibly redirected) request still goes to
+// the host of the request that started the chain, without a downgrade
+// from HTTPS to HTTP.
+func sameOrigin(req *http.Request) bool {
+	first := req
+	for first.Response != nil && first.Response.Request != nil {
+		first = first.Response.Request
+	}
+	return safeRedirect(first.URL, req.URL)
+}
+
+func safeRedirect(from, to *url.URL) bool {
+	if !strings.EqualFold(from.Host, to.Host) {
+		return false
+	}
+	return !(from.Scheme == "https" && to.Scheme == "http")
+}
+
+// checkRedirect keeps Go's limit of 10 redirects and drops any Authorization
+// header a caller set once the chain leaves the original host. (net/http
+// would still forward it to subdomains.)
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !safeRedirect(via[0].URL, req.URL) {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// RedactURL removes a password from a URL before it is printed.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// statusError describes an unexpected HTTP status. 401 and 403 point at the
+// credential settings for the host; the credentials are never included.
+func statusError(prefix string, resp *http.Response) error {
+	host := resp.Request.URL.Host
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if Credentials(host).Empty() {
+			return fmt.Errorf("%s: %s returned %d; configure credentials for it (auth in config.yaml, SWIFTSTACK_TOKEN_<HOST> or ~/.netrc)", prefix, host, resp.StatusCode)
+		}
+		return fmt.Errorf("%s: %s returned %d; check the credentials configured for it", prefix, host, resp.StatusCode)
+	}
+	return fmt.Errorf("%s: %s returned status %d", prefix, host, resp.StatusCode)
+}
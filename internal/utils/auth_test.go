@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/004Ongoro/swiftstack/internal/config"
+)
+
+func TestCredentials(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	os.WriteFile(netrc, []byte(`machine cdn.example.com login ci password n3trc
+macdef init
+  machine ignored.example.com login x password y
+
+default login anon password guest
+`), 0600)
+	t.Setenv("NETRC", netrc)
+	t.Setenv("SWIFTSTACK_TOKEN_REGISTRY_EXAMPLE_COM_8443", "env-token")
+
+	c := config.Default()
+	c.Registries = []config.Registry{{Name: "mirror", URL: "https://ignored.example.com/registry.json"}}
+	c.Auth = map[string]config.HostAuth{
+		"registry.example.com:8443": {Token: "config-token"},
+		"registry.example.com":      {Username: "bob", Password: "hunter2"},
+	}
+	config.Set(c)
+	defer config.Set(nil)
+
+	tests := []struct {
+		host string
+		want config.HostAuth
+	}{
+		{"registry.example.com:8443", config.HostAuth{Token: "env-token"}},
+		{"REGISTRY.example.com", config.HostAuth{Username: "bob", Password: "hunter2"}},
+		{"registry.example.com:9000", config.HostAuth{Username: "bob", Password: "hunter2"}},
+		{"cdn.example.com", config.HostAuth{Username: "ci", Password: "n3trc"}},
+		{"ignored.example.com", config.HostAuth{Username: "anon", Password: "guest"}},
+		{"cdn.thirdparty.example", config.HostAuth{}}, // not a registry: no default entry
+	}
+	for _, tt := range tests {
+		if got := Credentials(tt.host); got != tt.want {
+			t.Errorf("Credentials(%q) = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPClientRedirects(t *testing.T) {
+	var otherAuth string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherAuth = r.Header.Get("Authorization")
+		w.Write([]byte("slice"))
+	}))
+	defer other.Close()
+
+	var ownAuth []string
+	own := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ownAuth = append(ownAuth, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, other.URL+"/final", http.StatusFound)
+		case "/denied":
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer own.Close()
+
+	host := strings.TrimPrefix(own.URL, "http://")
+	c := config.Default()
+	c.Registries = []config.Registry{{Name: "mirror", URL: "https://ignored.example.com/registry.json"}}
+	c.Auth = map[string]config.HostAuth{host: {Token: "s3cret"}}
+	config.Set(c)
+	defer config.Set(nil)
+
+	if _, err := FetchRemote(own.URL + "/same"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ownAuth) != 2 || ownAuth[0] != "Bearer s3cret" || ownAuth[1] != "Bearer s3cret" {
+		t.Errorf("same-host redirect: Authorization = %q, want the token on both requests", ownAuth)
+	}
+
+	// A caller-set header must not follow the redirect either
+	req, _ := http.NewRequest(http.MethodGet, own.URL+"/cross", nil)
+	req.Header.Set("Authorization", "Bearer caller")
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if otherAuth != "" {
+		t.Errorf("cross-host redirect forwarded the caller's Authorization %q", otherAuth)
+	}
+	if _, err := FetchRemote(own.URL + "/cross"); err != nil {
+		t.Fatal(err)
+	}
+	if otherAuth != "" {
+		t.Errorf("cross-host redirect sent Authorization %q", otherAuth)
+	}
+
+	_, err = FetchRemote(own.URL + "/denied")
+	if err == nil || strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), "401") {
+		t.Errorf("error for 401 = %v; want the status without the token", err)
+	}
+}
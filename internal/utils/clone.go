@@ -0,0 +1,135 @@
+/*
+Package utils provides network and file system helpers.
+clone.go recreates a directory tree from a cached copy, sharing file data
+with the copy where the file system allows it: by reflink (a copy-on-write
+clone), by hardlink, or else by copying the bytes.
+*/
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Ways CloneTree materializes a file, from cheapest to most expensive.
+const (
+	CloneReflink  = "reflink"
+	CloneHardlink = "hardlink"
+	CloneCopy     = "copy"
+)
+
+// CloneOptions controls how CloneTree shares data with the source.
+type CloneOptions struct {
+	// Reflink tries a copy-on-write clone of every file first. It is
+	// supported on Linux file systems with FICLONE, such as btrfs and XFS.
+	Reflink bool
+	// Hardlink reports whether the file at the slash-separated path rel may
+	// be hardlinked when it cannot be reflinked. A hardlink shares the inode
+	// with the source, so only files that are replaced rather than edited in
+	// place (like those under node_modules) should be. Nil never hardlinks.
+	Hardlink func(rel string) bool
+}
+
+// CloneTree recreates the directories and regular files under src in dest,
+// which may already exist, and counts how many files each method handled.
+// A method that fails once is not tried again for the rest of the tree.
+// Reflinks and copies are made writable by their owner even when the source
+// file is read-only (see ProtectTree); hardlinks share its permissions.
+func CloneTree(src, dest string, opts CloneOptions) (map[string]int, error) {
+	counts := make(map[string]int)
+	canReflink, canLink := opts.Reflink, opts.Hardlink != nil
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Replace an existing file instead of writing into it: it may be a
+		// hardlink to the source
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		perm := info.Mode().Perm() | 0200
+		if canReflink {
+			if err := reflink(path, target, perm); err == nil {
+				counts[CloneReflink]++
+				return nil
+			}
+			canReflink = false
+		}
+		if canLink && opts.Hardlink(filepath.ToSlash(rel)) {
+			if err := os.Link(path, target); err == nil {
+				counts[CloneHardlink]++
+				return nil
+			}
+			canLink = false
+		}
+		if err := copyFileMode(path, target, perm); err != nil {
+			return err
+		}
+		counts[CloneCopy]++
+		return nil
+	})
+	if err != nil {
+		return counts, fmt.Errorf("fs: failed to clone %s: %w", src, err)
+	}
+	return counts, nil
+}
+
+// ProtectTree removes the write permissions of every regular file under
+// dir. Files hardlinked from the tree then fail to open for writing instead
+// of changing the tree (and every other project linked to it). Deleting or
+// replacing them still works, as it depends on the directory's permissions.
+func ProtectTree(dir string) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0222)
+	})
+	if err != nil {
+		return fmt.Errorf("fs: failed to write-protect %s: %w", dir, err)
+	}
+	return nil
+}
+
+// copyFileMode copies src to dst, which must not exist, with the given
+// permissions.
+func copyFileMode(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// Reflinks are only implemented with Linux's FICLONE; elsewhere CloneTree
+// falls back to hardlinks and copies.
+
+func reflink(src, dst string, perm os.FileMode) error { return errors.ErrUnsupported }
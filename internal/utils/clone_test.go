@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloneTree(t *testing.T) {
+	src, dest := filepath.Join(t.TempDir(), "tree"), filepath.Join(t.TempDir(), "project")
+	files := map[string]string{
+		"package.json":                    `{"name":"app"}`,
+		"src/index.js":                    "export {}",
+		"node_modules/react/index.js":     "react",
+		"node_modules/react/package.json": `{"name":"react"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ProtectTree(src); err != nil {
+		t.Fatal(err)
+	}
+
+	linkable := func(rel string) bool { return strings.HasPrefix(rel, "node_modules/") }
+	counts, err := CloneTree(src, dest, CloneOptions{Hardlink: linkable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[CloneHardlink] != 2 || counts[CloneCopy] != 2 {
+		t.Errorf("counts = %v, want 2 hardlinks and 2 copies", counts)
+	}
+	for name, content := range files {
+		if data, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+
+	// Copies are independent of the tree; hardlinks share it
+	same := func(name string) bool {
+		a, _ := os.Stat(filepath.Join(src, filepath.FromSlash(name)))
+		b, _ := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		return os.SameFile(a, b)
+	}
+	if same("package.json") || !same("node_modules/react/index.js") {
+		t.Error("only files under node_modules should be hardlinked")
+	}
+
+	// Copies are writable; hardlinks keep the tree's write protection
+	writable := func(name string) bool {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		return err == nil && info.Mode().Perm()&0200 != 0
+	}
+	if !writable("package.json") || writable("node_modules/react/index.js") {
+		t.Error("copies should be writable and hardlinks read-only")
+	}
+
+	// Cloning again over an existing project replaces its files
+	counts, err = CloneTree(src, dest, CloneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[CloneCopy] != 4 {
+		t.Errorf("second clone counts = %v, want 4 copies", counts)
+	}
+	for name, content := range files {
+		if data, _ := os.ReadFile(filepath.Join(src, filepath.FromSlash(name))); string(data) != content {
+			t.Errorf("cloning over a hardlink changed the tree's %s to %q", name, data)
+		}
+	}
+}
@@ -13,20 +13,39 @@ import (
 // This ensures that the lockfile is regenerated to match our merged package.json
 // without performing a full network download.
 func RunNpmLockUpdate(dir string) error {
-	// Check if npm is even installed first
-	_, err := exec.LookPath("npm")
+	return RunLockUpdate(dir, "npm")
+}
+
+// lockOnlyArgs lists, per package manager, the arguments that refresh the
+// lockfile without installing node_modules.
+var lockOnlyArgs = map[string][]string{
+	"npm":  {"install", "--package-lock-only"},
+	"pnpm": {"install", "--lockfile-only"},
+	"yarn": {"install", "--mode", "update-lockfile"},
+	"bun":  {"install", "--lockfile-only"},
+}
+
+// RunLockUpdate regenerates the lockfile in dir using the given package manager.
+func RunLockUpdate(dir, manager string) error {
+	args, ok := lockOnlyArgs[manager]
+	if !ok {
+		return fmt.Errorf("unsupported package manager %q", manager)
+	}
+
+	// Check if the package manager is even installed first
+	_, err := exec.LookPath(manager)
 	if err != nil {
-		return fmt.Errorf("npm not found in PATH: please install Node.js to use SwiftStack")
+		return fmt.Errorf("%s not found in PATH: please install it to use SwiftStack", manager)
 	}
 
 	// Prepare the command
-	cmd := exec.Command("npm", "install", "--package-lock-only")
+	cmd := exec.Command(manager, args...)
 	cmd.Dir = dir
 
 	// Execute and capture output
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("npm lock update failed: %w\nOutput: %s", err, string(output))
+		return fmt.Errorf("%s lock update failed: %w\nOutput: %s", manager, err, string(output))
 	}
 
 	return nil
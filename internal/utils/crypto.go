@@ -11,20 +11,27 @@ import (
 	"os"
 )
 
-// VerifyFileHash compares the SHA-256 hash of a file against an expected string.
-func VerifyFileHash(filePath, expectedHash string) error {
+// HashFile returns the hex-encoded SHA-256 of a file.
+func HashFile(filePath string) (string, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("crypto: could not open file for hashing: %w", err)
+		return "", fmt.Errorf("crypto: could not open file for hashing: %w", err)
 	}
 	defer f.Close()
 
 	h := sha256.New()
 	if _, err := io.Copy(h, f); err != nil {
-		return fmt.Errorf("crypto: failed to calculate hash: %w", err)
+		return "", fmt.Errorf("crypto: failed to calculate hash: %w", err)
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	actualHash := hex.EncodeToString(h.Sum(nil))
+// VerifyFileHash compares the SHA-256 hash of a file against an expected string.
+func VerifyFileHash(filePath, expectedHash string) error {
+	actualHash, err := HashFile(filePath)
+	if err != nil {
+		return err
+	}
 
 	if actualHash != expectedHash {
 		return fmt.Errorf("integrity error: hash mismatch!\nExpected: %s\nActual:   %s", expectedHash, actualHash)
@@ -0,0 +1,216 @@
+/*
+Package utils provides network and file system helpers.
+fetch.go dispatches slice and manifest locations to a Fetcher by URL scheme.
+http(s) and file are built in; other packages add schemes with RegisterFetcher.
+Bare paths (including Windows paths) are treated as file locations, and
+relative references are resolved against the manifest that listed them.
+*/
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fetcher retrieves resources for one or more URL schemes.
+type Fetcher interface {
+	// Download stores the resource at dest, using up to chunks parallel
+	// transfers where the scheme supports it.
+	Download(u *url.URL, dest string, chunks int) error
+	// Read returns a small resource (a signature or manifest) in full, or
+	// ErrNotFound when it does not exist.
+	Read(u *url.URL) ([]byte, error)
+}
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = map[string]Fetcher{
+		"http":  httpFetcher{},
+		"https": httpFetcher{},
+		"file":  fileFetcher{},
+	}
+)
+
+// RegisterFetcher makes f handle URLs with the given scheme, replacing any
+// earlier handler. It is meant to be called from init functions.
+func RegisterFetcher(scheme string, f Fetcher) {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	fetchers[strings.ToLower(scheme)] = f
+}
+
+// HasFetcher reports whether a handler is registered for scheme.
+func HasFetcher(scheme string) bool {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	_, ok := fetchers[strings.ToLower(scheme)]
+	return ok
+}
+
+// FetcherSchemes lists the registered schemes, sorted.
+func FetcherSchemes() []string {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	schemes := make([]string, 0, len(fetchers))
+	for s := range fetchers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func fetcherFor(u *url.URL) (Fetcher, error) {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	f, ok := fetchers[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("fetch: unsupported scheme %q in %s", u.Scheme, u.Redacted())
+	}
+	return f, nil
+}
+
+// Download fetches ref (a URL or local path) into dest.
+func Download(ref, dest string, chunks int) error {
+	u, err := ResolveURL(ref, "")
+	if err != nil {
+		return err
+	}
+	f, err := fetcherFor(u)
+	if err != nil {
+		return err
+	}
+	return f.Download(u, dest, chunks)
+}
+
+// ReadResource returns the content at ref (a URL or local path), or
+// ErrNotFound when it does not exist.
+func ReadResource(ref string) ([]byte, error) {
+	u, err := ResolveURL(ref, "")
+	if err != nil {
+		return nil, err
+	}
+	f, err := fetcherFor(u)
+	if err != nil {
+		return nil, err
+	}
+	return f.Read(u)
+}
+
+// ResolveURL turns a slice reference into an absolute URL. URLs with a scheme
+// are returned as they are; local paths become file:// URLs; relative
+// references are resolved against base, the location of the manifest that
+// listed them (a URL or a path), or the working directory when base is empty.
+func ResolveURL(ref, base string) (*url.URL, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("fetch: empty location")
+	}
+	if isLocalPath(ref) {
+		if filepath.IsAbs(ref) || isWindowsAbs(ref) || base == "" {
+			return fileURL(ref)
+		}
+		// Relative: resolve against the manifest's location below
+	} else if u, err := url.Parse(ref); err == nil && u.Scheme != "" {
+		return u, nil
+	}
+
+	if base == "" {
+		return fileURL(ref)
+	}
+	b, err := ResolveURL(base, "")
+	if err != nil {
+		return nil, err
+	}
+	if b.Scheme == "file" {
+		dir := filepath.Dir(filepath.FromSlash(filePath(b)))
+		return fileURL(filepath.Join(dir, filepath.FromSlash(ref)))
+	}
+	rel, err := url.Parse(filepath.ToSlash(ref))
+	if err != nil {
+		return nil, fmt.Errorf("fetch: invalid location %q: %w", ref, err)
+	}
+	return b.ResolveReference(rel), nil
+}
+
+// isLocalPath reports references without a URL scheme. A single letter
+// before the colon is a Windows drive, not a scheme.
+func isLocalPath(ref string) bool {
+	if isWindowsAbs(ref) || strings.HasPrefix(ref, `\\`) {
+		return true
+	}
+	u, err := url.Parse(ref)
+	return err != nil || u.Scheme == ""
+}
+
+// isWindowsAbs reports paths like C:\slices or C:/slices on any OS.
+func isWindowsAbs(p string) bool {
+	return len(p) >= 3 && p[1] == ':' && (p[2] == '\\' || p[2] == '/') &&
+		(p[0] >= 'a' && p[0] <= 'z' || p[0] >= 'A' && p[0] <= 'Z')
+}
+
+// fileURL converts a local path to a file:// URL.
+func fileURL(p string) (*url.URL, error) {
+	if isWindowsAbs(p) {
+		return &url.URL{Scheme: "file", Path: "/" + strings.ReplaceAll(p, `\`, "/")}, nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	return &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}, nil
+}
+
+// filePath converts a file:// URL back to a local path.
+func filePath(u *url.URL) string {
+	p := u.Path
+	if u.Opaque != "" {
+		p = u.Opaque // file:relative/path
+	}
+	// file:///C:/x has the path /C:/x
+	if len(p) >= 3 && p[0] == '/' && isWindowsAbs(p[1:]) {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// httpFetcher downloads over HTTP with parallel range requests.
+type httpFetcher struct{}
+
+func (httpFetcher) Download(u *url.URL, dest string, chunks int) error {
+	return DownloadFileConcurrent(u.String(), dest, chunks)
+}
+
+func (httpFetcher) Read(u *url.URL) ([]byte, error) {
+	return FetchRemote(u.String())
+}
+
+// fileFetcher copies from the local file system (or a mounted share).
+type fileFetcher struct{}
+
+func (fileFetcher) Download(u *url.URL, dest string, _ int) error {
+	src := filePath(u)
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	if err := CopyFile(src, dest); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("fetch: failed to copy %s: %w", src, err)
+	}
+	return nil
+}
+
+func (fileFetcher) Read(u *url.URL) ([]byte, error) {
+	data, err := os.ReadFile(filePath(u))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		ref, base, want string
+	}{
+		{"https://cdn.example.com/a.tar.zst", "/srv/registry.json", "https://cdn.example.com/a.tar.zst"},
+		{"slices/a.tar.zst", "https://r.example.com/v1/registry.json", "https://r.example.com/v1/slices/a.tar.zst"},
+		{"../a.tar.zst", "https://r.example.com/v1/registry.json", "https://r.example.com/a.tar.zst"},
+		{"slices/a.tar.zst", "/srv/registry.json", "file:///srv/slices/a.tar.zst"},
+		{"slices/a.tar.zst", "file:///srv/registry.json", "file:///srv/slices/a.tar.zst"},
+		{"/mnt/share/a.tar.zst", "https://r.example.com/registry.json", "file:///mnt/share/a.tar.zst"},
+		{`C:\Users\dev\a.tar.zst`, "", "file:///C:/Users/dev/a.tar.zst"},
+		{"oci://registry.local/slices/a:1.0", "", "oci://registry.local/slices/a:1.0"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveURL(tt.ref, tt.base)
+		if err != nil {
+			t.Errorf("ResolveURL(%q, %q): %v", tt.ref, tt.base, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ResolveURL(%q, %q) = %s; want %s", tt.ref, tt.base, got, tt.want)
+		}
+	}
+}
+
+type memFetcher map[string]string
+
+func (m memFetcher) Download(u *url.URL, dest string, _ int) error {
+	return os.WriteFile(dest, []byte(m[u.String()]), 0644)
+}
+
+func (m memFetcher) Read(u *url.URL) ([]byte, error) {
+	data, ok := m[u.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(data), nil
+}
+
+func TestDownloadSchemes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.tar.zst")
+	if err := os.WriteFile(src, []byte("slice"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bare paths and file:// URLs copy from disk
+	for _, ref := range []string{src, "file://" + filepath.ToSlash(src)} {
+		dest := filepath.Join(dir, "out")
+		if err := Download(ref, dest, 4); err != nil {
+			t.Fatalf("Download(%s): %v", ref, err)
+		}
+		if data, _ := os.ReadFile(dest); string(data) != "slice" {
+			t.Errorf("Download(%s) wrote %q", ref, data)
+		}
+	}
+	if _, err := ReadResource(src + ".sig"); err != ErrNotFound {
+		t.Errorf("missing file: got %v, want ErrNotFound", err)
+	}
+
+	// Registered handlers take over their scheme
+	if err := Download("mem://x", filepath.Join(dir, "mem"), 1); err == nil {
+		t.Error("expected an error for an unregistered scheme")
+	}
+	RegisterFetcher("mem", memFetcher{"mem://x": "from memory"})
+	if data, err := ReadResource("mem://x"); err != nil || string(data) != "from memory" {
+		t.Errorf("ReadResource(mem://x) = %q, %v", data, err)
+	}
+}
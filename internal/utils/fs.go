@@ -11,9 +11,23 @@ import (
 	"path/filepath"
 )
 
+// Conflict policies understood by MoveWithPolicy.
+const (
+	ConflictBackup    = "backup"    // rename the existing file to filename.bak
+	ConflictOverwrite = "overwrite" // replace the existing file
+	ConflictSkip      = "skip"      // keep the existing file, drop the incoming one
+	ConflictFail      = "fail"      // abort the move
+)
+
 // MoveWithBackup moves files from src to dst. If a file exists in dst, 
 // it renames the original to filename.bak before placing the new one.
 func MoveWithBackup(srcDir, dstDir string) error {
+	return MoveWithPolicy(srcDir, dstDir, ConflictBackup)
+}
+
+// MoveWithPolicy moves files from src to dst, resolving files that already
+// exist in dst according to policy.
+func MoveWithPolicy(srcDir, dstDir, policy string) error {
 	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -37,11 +51,20 @@ func MoveWithBackup(srcDir, dstDir string) error {
 			return os.MkdirAll(targetPath, 0755)
 		}
 
-		// If it's a file and it exists, create a backup
+		// If it's a file and it exists, apply the conflict policy
 		if _, err := os.Stat(targetPath); err == nil {
-			backupPath := targetPath + ".bak"
-			if err := os.Rename(targetPath, backupPath); err != nil {
-				return fmt.Errorf("fs: failed to create backup for %s: %w", targetPath, err)
+			switch policy {
+			case ConflictOverwrite:
+				// os.Rename replaces the target below.
+			case ConflictSkip:
+				return nil
+			case ConflictFail:
+				return fmt.Errorf("fs: %s already exists (conflict policy is %q)", relPath, policy)
+			default:
+				backupPath := targetPath + ".bak"
+				if err := os.Rename(targetPath, backupPath); err != nil {
+					return fmt.Errorf("fs: failed to create backup for %s: %w", targetPath, err)
+				}
 			}
 		}
 
@@ -66,4 +89,41 @@ func CopyFile(src, dst string) error {
 
 	_, err = io.Copy(destFile, sourceFile)
 	return err
-}
\ No newline at end of file
+}
+
+// WriteFileAtomic replaces path by writing a sibling temp file and renaming
+// it, so readers see either the old or the new content, never a partial file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := writeTemp(path, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("fs: failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeTemp writes data to a new temp file next to path, ready to be
+// renamed over it, and returns the temp file's name.
+func writeTemp(path string, data []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("fs: failed to create temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("fs: failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
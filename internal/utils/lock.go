@@ -0,0 +1,81 @@
+/*
+Package utils provides network and file system helpers.
+lock.go implements advisory file locks that serialize work on a shared
+path across processes, such as two 'swiftstack create' runs downloading the
+same slice into the cache. The lock is released when the process exits, so
+a crashed run never leaves a stale lock behind.
+*/
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by TryLock when another process holds the lock.
+var ErrLocked = errors.New("lock: held by another process")
+
+// FileLock is an advisory lock on a file, exclusive or shared.
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes an exclusive lock on path, creating the file if needed, and
+// blocks until it is available. If another process holds it, waiting (when
+// not nil) is called once before blocking.
+func Lock(path string, waiting func()) (*FileLock, error) {
+	return lock(path, true, true, waiting)
+}
+
+// RLock takes a shared lock on path: any number of processes may hold one
+// at the same time, but not while another holds the exclusive lock. It
+// blocks like Lock.
+func RLock(path string, waiting func()) (*FileLock, error) {
+	return lock(path, false, true, waiting)
+}
+
+// TryLock takes an exclusive lock on path without waiting, and returns
+// ErrLocked if any other lock on it is held.
+func TryLock(path string) (*FileLock, error) {
+	return lock(path, true, false, nil)
+}
+
+func lock(path string, exclusive, wait bool, waiting func()) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+
+	err = lockFile(f, exclusive, false)
+	if errors.Is(err, ErrLocked) && !wait {
+		f.Close()
+		return nil, err
+	}
+	if errors.Is(err, ErrLocked) {
+		if waiting != nil {
+			waiting()
+		}
+		err = lockFile(f, exclusive, true)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock: failed to lock %s: %w", path, err)
+	}
+	return &FileLock{f: f}, nil
+}
+
+// Unlock releases the lock. The lock file itself is left in place: deleting
+// it would let a waiting process lock a file that is no longer the one new
+// callers open.
+func (l *FileLock) Unlock() error {
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package utils
+
+import "os"
+
+// Platforms without file locking (js, wasip1, plan9) run unlocked.
+
+func lockFile(f *os.File, exclusive, wait bool) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "entry.lock")
+	first, err := Lock(path, func() { t.Error("the first lock should not wait") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan struct{})
+	acquired := make(chan *FileLock)
+	go func() {
+		l, err := Lock(path, func() { close(waited) })
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- l
+	}()
+
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second lock did not report waiting")
+	}
+	select {
+	case <-acquired:
+		t.Fatal("the second lock was acquired while the first was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l := <-acquired:
+		l.Unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second lock was not acquired after Unlock")
+	}
+}
+
+func TestSharedLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "entry.lock")
+	var readers []*FileLock
+	for i := 0; i < 2; i++ {
+		l, err := RLock(path, func() { t.Error("shared locks should not wait for each other") })
+		if err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, l)
+	}
+
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("TryLock while read = %v, want ErrLocked", err)
+	}
+	for _, l := range readers {
+		l.Unlock()
+	}
+	l, err := TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock after the readers left: %v", err)
+	}
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("second TryLock = %v, want ErrLocked", err)
+	}
+	l.Unlock()
+}
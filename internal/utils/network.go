@@ -1,100 +1,481 @@
 /*
 Package utils provides network and file system helpers.
-network.go handles high-speed, multi-part downloads with progress tracking.
+network.go handles high-speed, multi-part downloads, reporting their
+progress to the hook set with SetProgressHook.
+An unfinished download keeps a sidecar next to the destination that records
+which byte ranges are complete, so the next attempt only fetches the rest.
+Servers that do not support ranges or report no size are read in a single
+stream, and every request is retried with exponential backoff.
 */
 package utils
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// PartialSuffix is appended to the destination of an unfinished download to
+// name its sidecar. The sidecar is removed once the download completes.
+const PartialSuffix = ".partial"
+
+// partialSaveInterval is how often the sidecar is rewritten while chunks
+// are still running.
+const partialSaveInterval = time.Second
+
+// Retry and chunking tuning. They are variables so tests can shrink them.
+var (
+	// maxAttempts is how often a request is tried before giving up.
+	maxAttempts = 4
+	// retryBackoff is the pause after the first failure; it doubles after
+	// every further one.
+	retryBackoff = 500 * time.Millisecond
+	// minChunkSize is the smallest range worth a connection of its own, so
+	// small files are fetched with fewer chunks than configured.
+	minChunkSize int64 = 1 << 20
+)
+
+// errChanged reports that the server answered an If-Range request with the
+// full resource, i.e. it no longer matches the recorded validator.
+var errChanged = errors.New("the file changed on the server")
+
+// errNoRanges reports that the server answered a range request with the
+// full resource although it advertised range support.
+var errNoRanges = errors.New("the server ignored the range request")
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// retryableStatus reports whether a request that got code may succeed later.
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// withRetries calls fn until it succeeds, fails permanently or has been
+// tried maxAttempts times, pausing retryBackoff after the first failure and
+// twice as long after each further one.
+func withRetries(fn func() error) error {
+	wait := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) {
+			return err
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
 // DownloadResult represents the status of a chunk download.
 type DownloadResult struct {
 	Index int
 	Error error
 }
 
+// byteRange is the half-open range [Start, End) of a file.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// partial is the sidecar of an unfinished download: what was requested, the
+// validators the server sent for it, and the ranges already written.
+type partial struct {
+	URL          string      `json:"url"`
+	Size         int64       `json:"size"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"lastModified,omitempty"`
+	Done         []byteRange `json:"done"`
+}
+
+// validator is the If-Range value for the download: a strong ETag, or else
+// the Last-Modified date. Weak ETags may not be used with If-Range.
+func (p *partial) validator() string {
+	if p.ETag != "" && !strings.HasPrefix(p.ETag, "W/") {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// resumes reports whether old describes an earlier attempt at the same
+// download, so the ranges it recorded can be kept.
+func (p *partial) resumes(old *partial) bool {
+	return old.URL == p.URL && old.Size == p.Size && p.validator() != "" &&
+		old.ETag == p.ETag && old.LastModified == p.LastModified
+}
+
+// add records that [start, end) was written, merging adjacent ranges.
+func (p *partial) add(start, end int64) {
+	done := append(p.Done, byteRange{start, end})
+	sort.Slice(done, func(i, j int) bool { return done[i].Start < done[j].Start })
+	merged := done[:1]
+	for _, r := range done[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End {
+			last.End = max(last.End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	p.Done = merged
+}
+
+// missing returns the ranges of the file not written yet.
+func (p *partial) missing() []byteRange {
+	var out []byteRange
+	var pos int64
+	for _, r := range p.Done {
+		if r.Start > pos {
+			out = append(out, byteRange{pos, r.Start})
+		}
+		pos = max(pos, r.End)
+	}
+	if pos < p.Size {
+		out = append(out, byteRange{pos, p.Size})
+	}
+	return out
+}
+
+// chunkCount picks how many parallel requests fetch n bytes: one per
+// minChunkSize, between 1 and max.
+func chunkCount(n int64, max int) int {
+	c := n / minChunkSize
+	if c > int64(max) {
+		c = int64(max)
+	}
+	if c < 1 {
+		return 1
+	}
+	return int(c)
+}
+
+// splitRanges divides the missing ranges into about n parts of similar size,
+// for as many parallel requests.
+func splitRanges(ranges []byteRange, n int) []byteRange {
+	var total int64
+	for _, r := range ranges {
+		total += r.End - r.Start
+	}
+	if n < 1 {
+		n = 1
+	}
+	part := (total + int64(n) - 1) / int64(n)
+	var out []byteRange
+	for _, r := range ranges {
+		for start := r.Start; start < r.End; start += part {
+			out = append(out, byteRange{start, min(start+part, r.End)})
+		}
+	}
+	return out
+}
+
 // DownloadFileConcurrent downloads a file using multiple parallel connections.
-// It divides the file into 'chunks' to maximize bandwidth on slow/high-latency links.
+// It divides the file into up to 'chunks' ranges (fewer for small files) to maximize bandwidth on slow/high-latency links.
+// When an earlier attempt at the same URL left a sidecar at destPath +
+// PartialSuffix, only the ranges it lacks are requested, with If-Range so
+// that a file that changed on the server is downloaded again from scratch.
+// A server without range support, or one that does not report the size, is
+// read in a single stream. Failed requests are retried, and the error lists
+// every chunk that still failed.
 func DownloadFileConcurrent(url string, destPath string, chunks int) error {
-	// 1. Get the total file size first
-	resp, err := http.Head(url)
-	if err != nil {
+	// 1. Get the total file size and its validators first
+	var resp *http.Response
+	err := withRetries(func() error {
+		r, err := HTTPClient.Head(url)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		resp = r
+		if retryableStatus(r.StatusCode) {
+			return statusError("network", r)
+		}
+		return nil
+	})
+	if resp == nil {
 		return fmt.Errorf("network: failed to reach registry: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("network: registry returned status %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		return downloadStream(url, destPath)
+	case resp.StatusCode != http.StatusOK:
+		return statusError("network", resp)
+	case resp.ContentLength < 0 || resp.Header.Get("Accept-Ranges") != "bytes":
+		return downloadStream(url, destPath)
+	}
+	state := &partial{
+		URL:          RedactURL(url),
+		Size:         resp.ContentLength,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	// 2. Reopen the partial download, or create the destination file
+	out, resumed, err := openPartial(destPath, state)
+	if err != nil {
+		return fmt.Errorf("network: %w", err)
+	}
+	err = downloadMissing(url, out, destPath, state, chunks, resumed)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, errChanged) && resumed {
+		// The recorded ranges belong to an older version of the file
+		fmt.Printf("%s changed since the last attempt, starting over...\n", RedactURL(url))
+		os.Remove(destPath + PartialSuffix)
+		return DownloadFileConcurrent(url, destPath, chunks)
+	}
+	// On a fresh download, a full answer to If-Range means the server
+	// ignores ranges (or the file changed since the HEAD): stream it instead
+	if errors.Is(err, errNoRanges) || errors.Is(err, errChanged) && !resumed {
+		os.Remove(destPath + PartialSuffix)
+		return downloadStream(url, destPath)
+	}
+	if err != nil {
+		return fmt.Errorf("network: failed to download %s: %w", RedactURL(url), err)
+	}
+	os.Remove(destPath + PartialSuffix)
+	return nil
+}
+
+// openPartial opens destPath to continue the download recorded in its
+// sidecar, adopting the recorded ranges into state, or creates it afresh
+// when there is nothing to resume.
+func openPartial(destPath string, state *partial) (*os.File, bool, error) {
+	var old partial
+	if data, err := os.ReadFile(destPath + PartialSuffix); err == nil && json.Unmarshal(data, &old) == nil && state.resumes(&old) {
+		if info, err := os.Stat(destPath); err == nil && info.Size() == state.Size {
+			if out, err := os.OpenFile(destPath, os.O_WRONLY, 0); err == nil {
+				state.Done = old.Done
+				return out, true, nil
+			}
+		}
 	}
-	size := resp.ContentLength
 
-	// 2. Create the destination file
+	os.Remove(destPath + PartialSuffix)
 	out, err := os.Create(destPath)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+	if err := out.Truncate(state.Size); err != nil {
+		out.Close()
+		return nil, false, err
 	}
-	defer out.Close()
+	return out, false, nil
+}
 
-	var wg sync.WaitGroup
-	chunkSize := size / int64(chunks)
-	
-	fmt.Printf("Downloading %s in %d parallel chunks...\n", url, chunks)
+// downloadMissing fetches the ranges state lacks in parallel, recording each
+// write in the sidecar so an interrupted download can be resumed.
+func downloadMissing(url string, out *os.File, destPath string, state *partial, chunks int, resumed bool) error {
+	missing := state.missing()
+	if len(missing) == 0 {
+		return nil
+	}
+	var left int64
+	for _, r := range missing {
+		left += r.End - r.Start
+	}
+	ranges := splitRanges(missing, chunkCount(left, chunks))
+	if resumed {
+		fmt.Printf("Resuming %s, %d of %d bytes left...\n", RedactURL(url), left, state.Size)
+	} else {
+		fmt.Printf("Downloading %s in %d parallel chunks...\n", RedactURL(url), len(ranges))
+	}
 
-	for i := 0; i < chunks; i++ {
-		wg.Add(1)
-		
-		start := int64(i) * chunkSize
-		end := start + chunkSize - 1
-		if i == chunks-1 {
-			end = size - 1
+	// Without a validator, recorded ranges could not be trusted later
+	ifRange := state.validator()
+	var mu sync.Mutex
+	var saved time.Time
+	save := func() {
+		if ifRange == "" {
+			return
+		}
+		if data, err := json.Marshal(state); err == nil {
+			WriteFileAtomic(destPath+PartialSuffix, data, 0644)
+			saved = time.Now()
 		}
+	}
+	record := func(start, end int64, final bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if end > start {
+			state.add(start, end)
+		}
+		if final || time.Since(saved) >= partialSaveInterval {
+			save()
+		}
+	}
+
+	mu.Lock()
+	save()
+	mu.Unlock()
+
+	sizes := make([]int64, len(ranges))
+	for i, r := range ranges {
+		sizes[i] = r.End - r.Start
+	}
+	tr := newTracker(url, state.Size, state.Size-left, sizes)
 
-		go func(index int, start, end int64) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(ranges))
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(index int, r byteRange) {
 			defer wg.Done()
-			err := downloadChunk(url, out, start, end)
+			chunkRecord := func(start, end int64, final bool) {
+				tr.add(index, end-start)
+				record(start, end, final)
+			}
+			err := withRetries(func() error {
+				return downloadChunk(url, out, &r, ifRange, chunkRecord)
+			})
 			if err != nil {
-				fmt.Printf("Error downloading chunk %d: %v\n", index, err)
+				errs[index] = fmt.Errorf("chunk %d of %d: %w", index+1, len(ranges), err)
 			}
-		}(i, start, end)
+		}(i, r)
 	}
-
 	wg.Wait()
+
+	// These need a different approach rather than a list of failures
+	for _, target := range []error{errChanged, errNoRanges} {
+		for _, err := range errs {
+			if errors.Is(err, target) {
+				return target
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	tr.finish()
 	return nil
 }
 
 // downloadChunk fetches a specific byte range and writes it to the file at the correct offset.
-func downloadChunk(url string, out *os.File, start, end int64) error {
-	req, _ := http.NewRequest("GET", url, nil)
-	rangeHeader := fmt.Sprintf("bytes=%d-%d", start, end)
-	req.Header.Add("Range", rangeHeader)
+// Progress is passed to record as it is written, and r shrinks to what is
+// still missing, so a retry continues where the failed attempt stopped.
+func downloadChunk(url string, out *os.File, r *byteRange, ifRange string, record func(start, end int64, final bool)) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1))
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && ifRange != "":
+		return permanentError{errChanged}
+	case resp.StatusCode == http.StatusOK:
+		return permanentError{errNoRanges}
+	case retryableStatus(resp.StatusCode):
+		return statusError("network", resp)
+	default:
+		return permanentError{statusError("network", resp)}
+	}
+
 	// Write at the specific offset using WriteAt
 	// This allows multiple goroutines to write to the same file concurrently without overlapping.
-	_, err = io.Copy(newWriterAt(out, start), resp.Body)
+	w := &writerAtAdapter{file: out, offset: r.Start, record: record}
+	_, err = io.Copy(w, io.LimitReader(resp.Body, r.End-r.Start))
+	record(w.offset, w.offset, true)
+	r.Start = w.offset
+	if err == nil && r.Start < r.End {
+		err = io.ErrUnexpectedEOF
+	}
 	return err
 }
 
+// downloadStream fetches url in a single request, for servers that cannot
+// serve ranges or do not report the size. A failed attempt starts over.
+func downloadStream(url, destPath string) error {
+	fmt.Printf("Downloading %s in a single stream...\n", RedactURL(url))
+	var tr *tracker
+	err := withRetries(func() error {
+		resp, err := HTTPClient.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusOK:
+		case retryableStatus(resp.StatusCode):
+			return statusError("network", resp)
+		default:
+			return permanentError{statusError("network", resp)}
+		}
+
+		out, err := os.Create(destPath)
+		if err != nil {
+			return permanentError{err}
+		}
+		if tr == nil {
+			tr = newTracker(url, resp.ContentLength, 0, []int64{resp.ContentLength})
+		} else {
+			tr.restart()
+		}
+		n, err := io.Copy(progressWriter{out, tr}, resp.Body)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && resp.ContentLength >= 0 && n < resp.ContentLength {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("network: failed to download %s: %w", RedactURL(url), err)
+	}
+	tr.finish()
+	return nil
+}
+
+// progressWriter passes what a single stream writes on to its tracker.
+type progressWriter struct {
+	w  io.Writer
+	tr *tracker
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.tr.add(0, int64(n))
+	return n, err
+}
+
 // writerAt adapter to make os.File satisfy io.Writer for a specific offset
 type writerAtAdapter struct {
 	file   *os.File
 	offset int64
+	record func(start, end int64, final bool)
 }
 
 func (w *writerAtAdapter) Write(p []byte) (n int, err error) {
 	n, err = w.file.WriteAt(p, w.offset)
+	if w.record != nil && n > 0 {
+		w.record(w.offset, w.offset+int64(n), false)
+	}
 	w.offset += int64(n)
 	return
 }
-
-func newWriterAt(f *os.File, off int64) io.Writer {
-	return &writerAtAdapter{f, off}
-}
\ No newline at end of file
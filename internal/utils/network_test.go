@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cutWriter aborts the response after limit bytes of body.
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// fastRetries makes downloads retry without pausing and split even tiny
+// files into chunks.
+func fastRetries(t *testing.T, attempts int) {
+	oldAttempts, oldBackoff, oldMin := maxAttempts, retryBackoff, minChunkSize
+	maxAttempts, retryBackoff, minChunkSize = attempts, 0, 1
+	t.Cleanup(func() { maxAttempts, retryBackoff, minChunkSize = oldAttempts, oldBackoff, oldMin })
+}
+
+func TestDownloadResume(t *testing.T) {
+	fastRetries(t, 1)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	cut := map[string]int{} // Range header -> body bytes sent before the connection drops
+	var mu sync.Mutex
+	var ranges, ifRanges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		data := content
+		limit, ok := cut[r.Header.Get("Range")]
+		if r.Method == http.MethodGet {
+			ranges = append(ranges, r.Header.Get("Range"))
+			ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+		if ok {
+			w = &cutWriter{w, limit}
+		}
+		http.ServeContent(w, r, "slice.tar.zst", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "slice.tar.zst")
+	download := func(chunks int) ([]string, error) {
+		t.Helper()
+		mu.Lock()
+		ranges, ifRanges = nil, nil
+		mu.Unlock()
+		err := DownloadFileConcurrent(ts.URL+"/slice.tar.zst", dest, chunks)
+		sort.Strings(ranges)
+		return ranges, err
+	}
+	check := func(want []byte) {
+		t.Helper()
+		if data, _ := os.ReadFile(dest); !bytes.Equal(data, want) {
+			t.Errorf("downloaded %d bytes that do not match the file", len(data))
+		}
+		if _, err := os.Stat(dest + PartialSuffix); !os.IsNotExist(err) {
+			t.Errorf("the sidecar should be removed after a complete download")
+		}
+	}
+
+	// The second chunk drops after 100 bytes; the sidecar keeps what arrived
+	cut["bytes=500-999"] = 100
+	if _, err := download(2); err == nil {
+		t.Fatal("expected an error from the interrupted chunk")
+	}
+	if _, err := os.Stat(dest + PartialSuffix); err != nil {
+		t.Fatalf("no sidecar after an interrupted download: %v", err)
+	}
+
+	// Only the missing range is requested again, guarded by If-Range
+	delete(cut, "bytes=500-999")
+	got, err := download(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "bytes=600-799,bytes=800-999" || ifRanges[0] == "" {
+		t.Errorf("resume requested %v with If-Range %q", got, ifRanges[0])
+	}
+	check(content)
+
+	// A file that changed on the server is downloaded again from scratch
+	cut["bytes=500-999"] = 100
+	if _, err := download(2); err == nil {
+		t.Fatal("expected an error from the interrupted chunk")
+	}
+	delete(cut, "bytes=500-999")
+	mu.Lock()
+	content = bytes.Repeat([]byte("abcdefghij"), 100)
+	mu.Unlock()
+	if got, err := download(2); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(got, ",") != "bytes=0-499,bytes=500-999" {
+		t.Errorf("download of a changed file requested %v", got)
+	}
+	check(content)
+}
+
+func TestDownloadFallbacks(t *testing.T) {
+	fastRetries(t, 3)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	tests := []struct {
+		name     string
+		handler  func(w http.ResponseWriter, r *http.Request, failures int) // failures: earlier GETs
+		chunks   int
+		wantGETs int
+		wantErr  string
+	}{
+		{"ranges", func(w http.ResponseWriter, r *http.Request, _ int) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}, 4, 4, ""},
+		{"no range support", func(w http.ResponseWriter, r *http.Request, _ int) {
+			w.Write(content)
+		}, 4, 1, ""},
+		{"ranges advertised but ignored", func(w http.ResponseWriter, r *http.Request, _ int) {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Write(content)
+		}, 1, 2, ""},
+		{"ranges advertised but ignored with an ETag", func(w http.ResponseWriter, r *http.Request, _ int) {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(content)
+		}, 1, 2, ""},
+		{"no content length", func(w http.ResponseWriter, r *http.Request, _ int) {
+			w.Header().Set("Accept-Ranges", "bytes")
+			if r.Method == http.MethodGet {
+				w.(http.Flusher).Flush() // chunked encoding, no Content-Length
+				w.Write(content)
+			}
+		}, 4, 1, ""},
+		{"head not allowed", func(w http.ResponseWriter, r *http.Request, _ int) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write(content)
+		}, 4, 1, ""},
+		{"transient errors are retried", func(w http.ResponseWriter, r *http.Request, gets int) {
+			if r.Method == http.MethodGet && gets < 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}, 1, 3, ""},
+		{"retries run out", func(w http.ResponseWriter, r *http.Request, _ int) {
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}, 2, 6, "giving up after 3 attempts"},
+		{"client errors are not retried", func(w http.ResponseWriter, r *http.Request, _ int) {
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}, 2, 2, "status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			gets := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				n := gets
+				if r.Method == http.MethodGet {
+					gets++
+				}
+				mu.Unlock()
+				tt.handler(w, r, n)
+			}))
+			defer ts.Close()
+
+			dest := filepath.Join(t.TempDir(), "slice.tar.zst")
+			err := DownloadFileConcurrent(ts.URL+"/slice.tar.zst", dest, tt.chunks)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if tt.wantErr == "" {
+				if data, _ := os.ReadFile(dest); !bytes.Equal(data, content) {
+					t.Errorf("downloaded %d bytes that do not match the file", len(data))
+				}
+			}
+			if gets != tt.wantGETs {
+				t.Errorf("%d GET requests, want %d", gets, tt.wantGETs)
+			}
+		})
+	}
+}
+
+func TestChunkCount(t *testing.T) {
+	tests := []struct {
+		size int64
+		max  int
+		want int
+	}{
+		{0, 4, 1},
+		{minChunkSize / 2, 4, 1},
+		{3 * minChunkSize, 4, 3},
+		{300 * minChunkSize, 4, 4},
+		{300 * minChunkSize, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := chunkCount(tt.size, tt.max); got != tt.want {
+			t.Errorf("chunkCount(%d, %d) = %d, want %d", tt.size, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	fastRetries(t, 1)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantTotal  int64
+		wantChunks int
+	}{
+		{"ranges", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}, int64(len(content)), 4},
+		{"single stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content)
+		}, int64(len(content)), 1},
+		{"unknown size", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				w.(http.Flusher).Flush()
+				w.Write(content)
+			}
+		}, -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+			var mu sync.Mutex
+			var got []Progress
+			var log bytes.Buffer
+			logger := ProgressWriter(&log, false)
+			SetProgressHook(func(p Progress) {
+				mu.Lock()
+				got = append(got, p)
+				mu.Unlock()
+				logger(p)
+			})
+			defer SetProgressHook(nil)
+
+			dest := filepath.Join(t.TempDir(), "slice.tar.zst")
+			if err := DownloadFileConcurrent(ts.URL+"/slice.tar.zst", dest, 4); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) < 2 {
+				t.Fatalf("got %d progress snapshots, want a first and a last one", len(got))
+			}
+			first, last := got[0], got[len(got)-1]
+			if first.Done != 0 || first.Finished {
+				t.Errorf("first snapshot = %+v, want nothing done", first)
+			}
+			if !last.Finished || last.Done != int64(len(content)) || last.Total != tt.wantTotal {
+				t.Errorf("last snapshot: finished %v, %d of %d bytes, want finished, %d of %d",
+					last.Finished, last.Done, last.Total, len(content), tt.wantTotal)
+			}
+			var sum int64
+			for _, c := range last.Chunks {
+				sum += c.Done
+			}
+			if len(last.Chunks) != tt.wantChunks || sum != last.Done {
+				t.Errorf("%d chunks with %d bytes, want %d chunks with %d", len(last.Chunks), sum, tt.wantChunks, last.Done)
+			}
+			if !strings.Contains(log.String(), "Downloaded 1000 B in ") {
+				t.Errorf("log = %q, want a line for the finished download", log.String())
+			}
+		})
+	}
+}
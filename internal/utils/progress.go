@@ -0,0 +1,250 @@
+/*
+Package utils provides network and file system helpers.
+progress.go reports how HTTP downloads advance. A hook installed with
+SetProgressHook receives snapshots of the running download: bytes per
+chunk, the total, throughput and ETA. ProgressWriter renders them as a bar
+on a terminal and as periodic log lines elsewhere.
+*/
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a running download.
+type Progress struct {
+	URL      string          // redacted
+	Total    int64           // -1 when the server did not report the size
+	Done     int64           // including ranges resumed from an earlier attempt
+	Chunks   []ChunkProgress // one per parallel request, a single one for a stream
+	Rate     float64         // bytes per second since this attempt started
+	ETA      time.Duration   // -1 when unknown
+	Elapsed  time.Duration
+	Finished bool // the last snapshot of a successful download
+}
+
+// ChunkProgress is how far one parallel request got. Size is -1 when the
+// total is unknown.
+type ChunkProgress struct {
+	Done int64
+	Size int64
+}
+
+// Fraction returns how much of the download is done, between 0 and 1, or
+// -1 when the total is unknown.
+func (p Progress) Fraction() float64 {
+	if p.Total < 0 {
+		return -1
+	}
+	if p.Total == 0 {
+		return 1
+	}
+	return float64(p.Done) / float64(p.Total)
+}
+
+// ProgressFunc receives download progress. It is called from the
+// downloading goroutines, at most every progressInterval per download and
+// once more when it finishes, and must not block.
+type ProgressFunc func(Progress)
+
+// progressInterval throttles the snapshots sent to the hook.
+const progressInterval = 200 * time.Millisecond
+
+var (
+	progressMu   sync.RWMutex
+	progressHook ProgressFunc
+)
+
+// SetProgressHook makes fn receive the progress of every HTTP download, or
+// turns reporting off when fn is nil.
+func SetProgressHook(fn ProgressFunc) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progressHook = fn
+}
+
+// tracker collects the progress of one download and forwards it to the
+// hook. A nil tracker, used when no hook is set, ignores every call.
+type tracker struct {
+	mu    sync.Mutex
+	hook  ProgressFunc
+	p     Progress
+	start time.Time
+	base  int64 // bytes done before this attempt, for the rate
+	sent  time.Time
+}
+
+// newTracker starts tracking a download of total bytes, done of which are
+// already on disk, fetched by one request per entry of sizes.
+func newTracker(url string, total, done int64, sizes []int64) *tracker {
+	progressMu.RLock()
+	hook := progressHook
+	progressMu.RUnlock()
+	if hook == nil {
+		return nil
+	}
+	t := &tracker{hook: hook, start: time.Now(), base: done}
+	t.p = Progress{URL: RedactURL(url), Total: total, Done: done, ETA: -1}
+	for _, s := range sizes {
+		t.p.Chunks = append(t.p.Chunks, ChunkProgress{Size: s})
+	}
+	t.hook(t.snapshot())
+	return t
+}
+
+// add records n more bytes written by the given chunk.
+func (t *tracker) add(chunk int, n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done += n
+	t.p.Chunks[chunk].Done += n
+	if time.Since(t.sent) >= progressInterval {
+		t.hook(t.snapshot())
+	}
+}
+
+// restart forgets the bytes of a failed attempt that starts over.
+func (t *tracker) restart() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done, t.base, t.start = 0, 0, time.Now()
+	for i := range t.p.Chunks {
+		t.p.Chunks[i].Done = 0
+	}
+}
+
+// finish sends the last snapshot of a successful download.
+func (t *tracker) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Finished = true
+	t.hook(t.snapshot())
+}
+
+// snapshot fills in the rate and ETA. The caller holds t.mu.
+func (t *tracker) snapshot() Progress {
+	p := t.p
+	p.Chunks = append([]ChunkProgress(nil), t.p.Chunks...)
+	p.Elapsed = time.Since(t.start)
+	if secs := p.Elapsed.Seconds(); secs > 0 {
+		p.Rate = float64(p.Done-t.base) / secs
+	}
+	if p.Finished {
+		p.ETA = 0
+	} else if p.Total >= 0 && p.Rate > 0 {
+		p.ETA = time.Duration(float64(p.Total-p.Done) / p.Rate * float64(time.Second))
+	}
+	t.sent = time.Now()
+	return p
+}
+
+// progressLogInterval is how often ProgressWriter logs a line when it is not
+// writing to a terminal.
+const progressLogInterval = 5 * time.Second
+
+// ProgressWriter returns a hook that renders progress to w: on a terminal
+// (tty) as a bar redrawn in place, otherwise as a log line every few
+// seconds and one when the download finishes.
+func ProgressWriter(w io.Writer, tty bool) ProgressFunc {
+	var mu sync.Mutex
+	var logged time.Time
+	return func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if tty {
+			end := ""
+			if p.Finished {
+				end = "\n"
+			}
+			fmt.Fprintf(w, "\r%s\x1b[K%s", progressBar(p), end)
+			return
+		}
+		switch {
+		case p.Finished:
+			fmt.Fprintf(w, "  Downloaded %s in %s (%s/s)\n", FormatBytes(p.Done), p.Elapsed.Round(time.Second/10), FormatBytes(int64(p.Rate)))
+		case p.Done > 0 && time.Since(logged) >= progressLogInterval:
+			fmt.Fprintf(w, "  %s\n", progressLine(p))
+		default:
+			return
+		}
+		logged = time.Now()
+	}
+}
+
+// progressBarWidth is the number of cells of the bar drawn on terminals.
+const progressBarWidth = 30
+
+// progressBar renders p on one line: "[=====>    ]  45%  135.0 MiB/300.0 MiB
+// 12.3 MiB/s  ETA 13s", or without the bar when the total is unknown.
+func progressBar(p Progress) string {
+	rate := FormatBytes(int64(p.Rate)) + "/s"
+	f := p.Fraction()
+	if f < 0 {
+		return fmt.Sprintf("  %s  %s", FormatBytes(p.Done), rate)
+	}
+	filled := int(f * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("  [%s] %3.0f%%  %s/%s  %s  ETA %s",
+		bar, f*100, FormatBytes(p.Done), FormatBytes(p.Total), rate, formatETA(p.ETA))
+}
+
+// progressLine renders p for logs, with the share of every chunk when there
+// are several: "45% (135.0 MiB of 300.0 MiB) at 12.3 MiB/s, ETA 13s;
+// chunks 40% 50% 45% 45%".
+func progressLine(p Progress) string {
+	rate := FormatBytes(int64(p.Rate)) + "/s"
+	f := p.Fraction()
+	if f < 0 {
+		return fmt.Sprintf("%s at %s", FormatBytes(p.Done), rate)
+	}
+	line := fmt.Sprintf("%.0f%% (%s of %s) at %s, ETA %s",
+		f*100, FormatBytes(p.Done), FormatBytes(p.Total), rate, formatETA(p.ETA))
+	if len(p.Chunks) > 1 {
+		shares := make([]string, len(p.Chunks))
+		for i, c := range p.Chunks {
+			shares[i] = "100%"
+			if c.Size > 0 {
+				shares[i] = fmt.Sprintf("%.0f%%", float64(c.Done)*100/float64(c.Size))
+			}
+		}
+		line += "; chunks " + strings.Join(shares, " ")
+	}
+	return line
+}
+
+func formatETA(d time.Duration) string {
+	if d < 0 {
+		return "?"
+	}
+	return d.Round(time.Second).String()
+}
+
+// FormatBytes renders a byte count with binary units ("12.3 MiB").
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -4,31 +4,248 @@ Package utils handles synchronization of remote resources.
 package utils
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+
+	"github.com/004Ongoro/swiftstack/internal/models"
 )
 
-// FetchRemoteManifest downloads the latest manifest from the provided URL 
-// and saves it to the local destination.
-func FetchRemoteManifest(url, dest string) error {
-	resp, err := http.Get(url)
+// ErrNotFound is returned by FetchRemote when the server answers 404.
+var ErrNotFound = errors.New("sync: resource not found")
+
+// ErrNoPreviousManifest is returned by RestorePreviousManifest when no
+// earlier manifest was kept.
+var ErrNoPreviousManifest = errors.New("sync: no previous manifest to restore")
+
+// Files kept next to a synced manifest.
+const (
+	PreviousSuffix = ".prev" // the manifest replaced by the last sync
+	metaSuffix     = ".meta" // validators for conditional requests
+	sigSuffix      = ".sig"
+)
+
+// ManifestVerifier inspects a downloaded manifest and its detached signature
+// (nil when the registry publishes none) before it replaces the local copy.
+type ManifestVerifier func(manifest, signature []byte) error
+
+// syncMeta records the validators the server sent with the manifest.
+type syncMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// FetchRemoteManifest downloads the latest manifest from the provided URL
+// (or any location ReadResource understands) together with its optional
+// detached signature (url + ".sig"), passes both to verify and only then
+// saves them to dest and dest + ".sig".
+//
+// The request is conditional on the validators of the previous sync, so an
+// unchanged manifest is not downloaded again. Files are replaced atomically
+// and the manifest being replaced is kept at dest + PreviousSuffix. The
+// returned bool reports whether dest changed.
+func FetchRemoteManifest(url, dest string, verify ManifestVerifier) (bool, error) {
+	old, oldErr := os.ReadFile(dest)
+	oldSig, _ := os.ReadFile(dest + sigSuffix)
+
+	var meta syncMeta
+	if oldErr == nil {
+		if raw, err := os.ReadFile(dest + metaSuffix); err == nil {
+			json.Unmarshal(raw, &meta)
+		}
+	}
+
+	data, newMeta, err := fetchManifest(url, meta)
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		// 304: keep the local copy unless it no longer passes verification
+		// (e.g. a key was trusted since), in which case fetch it again
+		if verify == nil || verify(old, oldSig) == nil {
+			return false, nil
+		}
+		if data, newMeta, err = fetchManifest(url, syncMeta{}); err != nil {
+			return false, err
+		}
+	}
+
+	sig, err := ReadResource(url + sigSuffix)
+	if errors.Is(err, ErrNotFound) {
+		sig = nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if verify != nil {
+		if err := verify(data, sig); err != nil {
+			return false, fmt.Errorf("sync: manifest rejected: %w", err)
+		}
+	}
+	// An error page or a truncated body must not replace a good manifest
+	var m models.RemoteManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return false, fmt.Errorf("sync: %s is not a valid manifest: %w", RedactURL(url), err)
+	}
+
+	changed := oldErr != nil || !bytes.Equal(old, data) || !bytes.Equal(oldSig, sig)
+	if changed {
+		if err := replaceManifest(dest, old, oldSig, oldErr == nil, data, sig); err != nil {
+			return false, err
+		}
+	}
+
+	if raw, err := json.Marshal(newMeta); err == nil {
+		// Losing the validators only costs a full download next time
+		WriteFileAtomic(dest+metaSuffix, raw, 0644)
+	}
+	return changed, nil
+}
+
+// replaceManifest keeps the current manifest as the rollback copy and
+// atomically writes the new manifest and signature.
+func replaceManifest(dest string, old, oldSig []byte, hadOld bool, data, sig []byte) error {
+	if hadOld {
+		if err := writeOrRemove(dest+PreviousSuffix, old); err != nil {
+			return fmt.Errorf("sync: failed to keep previous manifest: %w", err)
+		}
+		if err := writeOrRemove(dest+PreviousSuffix+sigSuffix, oldSig); err != nil {
+			return fmt.Errorf("sync: failed to keep previous signature: %w", err)
+		}
+	}
+
+	// Keep the signature next to the manifest so it can be re-verified on load
+	if err := writeManifestPair(dest, data, sig); err != nil {
+		return fmt.Errorf("sync: failed to write local manifest: %w", err)
+	}
+	return nil
+}
+
+// writeManifestPair replaces the manifest at dest and its signature (removed
+// when sig is nil). Both are written to temp files before either is renamed
+// into place, so a failed write leaves the old pair intact.
+func writeManifestPair(dest string, data, sig []byte) error {
+	tmp, err := writeTemp(dest, data, 0644)
+	if err != nil {
+		return err
+	}
+	sigTmp := ""
+	if sig != nil {
+		if sigTmp, err = writeTemp(dest+sigSuffix, sig, 0644); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		if sigTmp != "" {
+			os.Remove(sigTmp)
+		}
+		return err
+	}
+	if sigTmp == "" {
+		return writeOrRemove(dest+sigSuffix, nil)
+	}
+	if err := os.Rename(sigTmp, dest+sigSuffix); err != nil {
+		os.Remove(sigTmp)
+		return err
+	}
+	return nil
+}
+
+// RestorePreviousManifest swaps dest back to the manifest it replaced.
+// The next sync downloads the manifest in full again.
+func RestorePreviousManifest(dest string) error {
+	prev, err := os.ReadFile(dest + PreviousSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoPreviousManifest
+	}
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	prevSig, _ := os.ReadFile(dest + PreviousSuffix + sigSuffix)
+
+	if err := writeManifestPair(dest, prev, prevSig); err != nil {
+		return fmt.Errorf("sync: failed to restore manifest: %w", err)
+	}
+
+	for _, p := range []string{dest + PreviousSuffix, dest + PreviousSuffix + sigSuffix, dest + metaSuffix} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeOrRemove atomically writes data to path, or removes path when data is nil.
+func writeOrRemove(path string, data []byte) error {
+	if data == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
+
+// fetchManifest reads a manifest from any supported location. Only HTTP
+// requests are conditional; other schemes are read in full every time.
+func fetchManifest(ref string, meta syncMeta) ([]byte, syncMeta, error) {
+	u, err := ResolveURL(ref, "")
+	if err != nil {
+		return nil, meta, err
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return fetchConditional(u.String(), meta)
+	}
+	data, err := ReadResource(u.String())
+	return data, syncMeta{}, err
+}
+
+// fetchConditional GETs url with If-None-Match / If-Modified-Since from meta.
+// It returns nil data when the server answers 304 Not Modified.
+func fetchConditional(url string, meta syncMeta) ([]byte, syncMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, meta, fmt.Errorf("sync: %w", err)
+	}
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("sync: failed to fetch manifest: %w", err)
+		return nil, meta, fmt.Errorf("sync: failed to fetch %s: %w", RedactURL(url), err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("sync: registry server returned %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, meta, nil
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, meta, ErrNotFound
+	default:
+		return nil, meta, statusError("sync", resp)
 	}
 
-	out, err := os.Create(dest)
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("sync: failed to create local manifest: %w", err)
+		return nil, meta, fmt.Errorf("sync: failed to read %s: %w", RedactURL(url), err)
 	}
-	defer out.Close()
+	return data, syncMeta{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+}
 
-	_, err = io.Copy(out, resp.Body)
-	return err
-}
\ No newline at end of file
+// FetchRemote downloads a small resource fully into memory.
+func FetchRemote(url string) ([]byte, error) {
+	data, _, err := fetchConditional(url, syncMeta{})
+	return data, err
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchRemoteManifestConditional(t *testing.T) {
+	manifest := `{"bases":[]}`
+	status := 0 // 0 serves the manifest, anything else is returned as an error
+	var conditional int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if filepath.Ext(r.URL.Path) == ".sig" {
+			http.NotFound(w, r)
+			return
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		etag := `"` + manifest + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(manifest))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "default.json")
+	fetch := func() bool {
+		t.Helper()
+		changed, err := FetchRemoteManifest(ts.URL+"/registry.json", dest, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changed
+	}
+	content := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	if !fetch() {
+		t.Error("first sync should report a change")
+	}
+	if fetch() || conditional != 1 {
+		t.Errorf("second sync should be a 304 (conditional requests: %d)", conditional)
+	}
+
+	manifest = `{"bases":[{"id":"next-base"}]}`
+	if !fetch() {
+		t.Error("sync after an update should report a change")
+	}
+	if content(dest) != manifest || content(dest+PreviousSuffix) != `{"bases":[]}` {
+		t.Errorf("manifest %q, previous %q", content(dest), content(dest+PreviousSuffix))
+	}
+
+	// A failing server must leave the local manifest untouched
+	status = http.StatusInternalServerError
+	if _, err := FetchRemoteManifest(ts.URL+"/registry.json", dest, nil); err == nil {
+		t.Error("expected an error from a failing server")
+	}
+	if content(dest) != manifest {
+		t.Errorf("failed sync changed the manifest to %q", content(dest))
+	}
+
+	// Nor may a body that is not a manifest, such as an error page
+	status, manifest = 0, `<html>Service Unavailable</html>`
+	if _, err := FetchRemoteManifest(ts.URL+"/registry.json", dest, nil); err == nil {
+		t.Error("expected an error for a body that is not a manifest")
+	}
+	if content(dest) == manifest {
+		t.Error("an invalid manifest replaced the local copy")
+	}
+
+	if err := RestorePreviousManifest(dest); err != nil {
+		t.Fatal(err)
+	}
+	if content(dest) != `{"bases":[]}` {
+		t.Errorf("rollback left %q", content(dest))
+	}
+	if err := RestorePreviousManifest(dest); err != ErrNoPreviousManifest {
+		t.Errorf("second rollback: got %v, want ErrNoPreviousManifest", err)
+	}
+}